@@ -3,6 +3,8 @@ package deploy
 import (
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -38,6 +40,15 @@ type deployEvent struct {
 	event        *progress.Event
 	taskProgress progress.ProgressEvent
 	yml          *ymlConfig
+	options      ssh.Options
+	tunnels      []*tunnel
+}
+
+// tunnel is a forwarding opened by a TUNNEL step, it stays open until the
+// file is deployed.
+type tunnel struct {
+	forward  ssh.Forward
+	listener net.Listener
 }
 
 func (e *deployEvent) SetStatus(status progress.Status, message string) {
@@ -47,10 +58,32 @@ func (e *deployEvent) SetStatus(status progress.Status, message string) {
 }
 
 func runDeploy(cmd *cobra.Command, args []string) error {
-	trust, err := cmd.Flags().GetBool("trust")
+	base, err := ssh.NewOptions(cmd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	configFile, err := cmd.Flags().GetString("ssh-config")
 	if err != nil {
 		panic(err)
 	}
+	config, err := ssh.LoadConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	vaultFile, err := cmd.Flags().GetString("vault")
+	if err != nil {
+		panic(err)
+	}
+	vaultPassphraseFile, err := cmd.Flags().GetString("vault-password-file")
+	if err != nil {
+		panic(err)
+	}
+	if err := loadVault(vaultFile, vaultPassphraseFile, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
 	deployCommand = struct {
 		cmd  *cobra.Command
 		args []string
@@ -60,14 +93,19 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 	}
 	progress := progress.NewEventProgress(cmd)
 	progress.StartEvent()
-	deploy(args, trust, progress)
+	deploy(args, base, config, progress)
 	progress.StopEvent()
 	return nil
 }
 
-func deploy(args []string, trust bool, taskProgress progress.ProgressEvent) {
+func deploy(args []string, base ssh.Options, config *ssh.Config, taskProgress progress.ProgressEvent) {
 	wg := sync.WaitGroup{}
-	server := make(map[string]any)
+	manager := ssh.NewManager()
+	defer manager.Close()
+	// files for the same server run in order, as they use the same working
+	// directory, and share its connection when they log in the same way
+	servers := []string{}
+	files := make(map[string][]*deployEvent)
 	id := uint32(0)
 	for _, file := range args {
 		id += 1
@@ -77,45 +115,97 @@ func deploy(args []string, trust bool, taskProgress progress.ProgressEvent) {
 		}
 		yml, err := parseYml(file)
 		if err != nil {
-			d.SetStatus(progress.FAILED, "invalid yaml file : "+file)
+			d.SetStatus(progress.FAILED, "invalid yaml file : "+file+" : "+err.Error())
 			continue
 		}
 		if err := validateYml(yml); err != nil {
 			d.SetStatus(progress.FAILED, err.Error())
 			continue
 		}
-		if _, ok := server[yml.Credential.source+"@"+yml.Credential.username]; ok {
-			d.SetStatus(progress.CANCELLED, "duplicate server entry : "+yml.Credential.source+"@"+yml.Credential.username)
-			continue
-		}
-		server[yml.Credential.source+"@"+yml.Credential.username] = nil
 		if yml.Name != "" {
 			d.event.EventName = yml.Name
 		}
-		d.SetStatus(progress.STARTED, "Starting...")
-		wg.Add(1)
 		d.yml = yml
-		go start(&wg, d, trust)
+		d.options = connectOptions(yml.Credential, base, config)
+		server := ssh.ConnectionKey(d.options)
+		if _, ok := files[server]; ok {
+			d.SetStatus(progress.STARTED, "Waiting for "+server)
+		} else {
+			servers = append(servers, server)
+			d.SetStatus(progress.STARTED, "Starting...")
+		}
+		files[server] = append(files[server], d)
+	}
+	for _, server := range servers {
+		wg.Add(1)
+		go func(files []*deployEvent) {
+			defer wg.Done()
+			for _, d := range files {
+				start(d, manager)
+			}
+		}(files[server])
 	}
 	wg.Wait()
 }
 
-func start(wg *sync.WaitGroup, file *deployEvent, trusServerHostKey bool) {
-	defer wg.Done()
-	// initialize ssh client
-	sshClient, err := ssh.ConnectWithPassword(ssh.Options{
-		Server:          file.yml.Credential.source,
-		Port:            22,
-		User:            file.yml.Credential.username,
-		Password:        file.yml.Credential.password,
-		TrustServerHost: trusServerHostKey,
-		SftpConcurrency: false,
-	})
+func connectOptions(credential credential, base ssh.Options, config *ssh.Config) ssh.Options {
+	options := base
+	options.Server = credential.source
+	options.Port = credential.port
+	options.User = credential.username
+	options.Password = credential.password
+	if provider := ssh.PasswordProvider(credential.passwordFile, credential.passwordCmd); provider != nil {
+		options.PasswordProvider = provider
+	}
+	options.KeyFile = credential.keyFile
+	options.Passphrase = credential.passphrase
+	options.Answers = credential.answers
+	options.ForwardAgent = credential.forwardAgent
+	if credential.proxy != "" {
+		options.Proxy = credential.proxy
+	}
+	options.SftpConcurrency = false
+	for _, j := range credential.jump {
+		hop := base
+		hop.Server = j.source
+		hop.Port = j.port
+		hop.User = j.username
+		hop.Password = j.password
+		if provider := ssh.PasswordProvider(j.passwordFile, j.passwordCmd); provider != nil {
+			hop.PasswordProvider = provider
+		}
+		hop.KeyFile = j.keyFile
+		hop.Passphrase = j.passphrase
+		hop.Answers = j.answers
+		if j.proxy != "" {
+			hop.Proxy = j.proxy
+		}
+		options.Jump = append(options.Jump, hop)
+	}
+	given := len(options.Jump)
+	config.Apply(&options)
+	// the hops of a ProxyJump entry log in as a jump host given without a
+	// password file or command does
+	for i := given; i < len(options.Jump); i++ {
+		options.Jump[i].PasswordProvider = base.PasswordProvider
+	}
+	return options
+}
+
+func start(file *deployEvent, manager *ssh.Manager) {
+	sshClient, err := manager.Connect(file.options)
 	if err != nil {
 		file.SetStatus(progress.FAILED, err.Error())
 		return
 	}
 	defer sshClient.Close()
+	runSteps(file, sshClient)
+}
+
+// runSteps runs the steps of file over sshClient, reconnecting when the
+// connection was lost.
+func runSteps(file *deployEvent, sshClient ssh.SshSession) {
+	defer closeTunnels(file)
 	// create workingspace directory
 	workingDirectory := ".mdeploy"
 	sshClient.RemoveAll(workingDirectory)
@@ -125,39 +215,98 @@ func start(wg *sync.WaitGroup, file *deployEvent, trusServerHostKey bool) {
 	// run tasks
 	for _, s := range file.yml.Steps {
 		file.SetStatus(progress.RUNNING, s.task+" "+s.description)
-		switch s.task {
-		case COPYTOSERVER_TASK:
-			if err := copyToRemote(file, sshClient, s.param); err != nil {
-				file.SetStatus(progress.FAILED, COPYTOSERVER_TASK+" "+err.Error())
-				return
-			}
-		case COPYFROMSERVER_TASK:
-			if err := copyFromRemote(file, sshClient, s.param); err != nil {
-				file.SetStatus(progress.FAILED, COPYFROMSERVER_TASK+" "+err.Error())
-				return
-			}
-		case RUN_TASK:
-			param := strings.Split(s.param["file"].(string), " ")
-			if err := runCommand(file, sshClient, workingDirectory, param[0], param[1:]); err != nil {
-				file.SetStatus(progress.FAILED, RUN_TASK+" "+err.Error())
+		if !sshClient.Connected() {
+			if err := reconnect(file, sshClient); err != nil {
+				file.SetStatus(progress.FAILED, s.task+" connection lost : "+err.Error())
 				return
 			}
-		case EXEC_TASK:
-			if err := execCommand(file, sshClient, s.param["command"].(string), nil); err != nil {
-				file.SetStatus(progress.FAILED, EXEC_TASK+" "+err.Error())
-				return
+		}
+		err := runStep(file, sshClient, workingDirectory, s)
+		// commands may have run before the connection broke, only transfers
+		// are repeated
+		if err != nil && !sshClient.Connected() && (s.task == COPYTOSERVER_TASK || s.task == COPYFROMSERVER_TASK || s.task == SYNC_TASK) {
+			file.SetStatus(progress.RUNNING, s.task+" connection lost, reconnecting...")
+			if err = reconnect(file, sshClient); err == nil {
+				file.SetStatus(progress.RUNNING, s.task+" "+s.description)
+				err = runStep(file, sshClient, workingDirectory, resumed(s))
 			}
-		case DELAY_TASK:
-			sec := s.param["seconds"].(int)
-			time.Sleep(time.Duration(sec) * time.Second)
-		default:
-			file.SetStatus(progress.FAILED, "Invalid Task : "+s.task)
+		}
+		if err != nil {
+			file.SetStatus(progress.FAILED, s.task+" "+err.Error())
 			return
 		}
 	}
 	file.SetStatus(progress.COMPLETED, "Completed")
 }
 
+// resumed returns the transfer step s continuing in the files and
+// directories its interrupted run left.
+func resumed(s steps) steps {
+	s.param = maps.Clone(s.param)
+	s.param["resume"] = true
+	return s
+}
+
+// reconnect opens the connection again along with the tunnels running over
+// it.
+func reconnect(file *deployEvent, sshClient ssh.SshSession) error {
+	if err := sshClient.Reconnect(); err != nil {
+		return err
+	}
+	for _, t := range file.tunnels {
+		t.listener.Close()
+		l, err := sshClient.Forward(t.forward)
+		if err != nil {
+			return fmt.Errorf("failed to forward %s : %w", t.forward, err)
+		}
+		t.listener = l
+	}
+	return nil
+}
+
+func closeTunnels(file *deployEvent) {
+	for _, t := range file.tunnels {
+		t.listener.Close()
+	}
+	file.tunnels = nil
+}
+
+func runStep(file *deployEvent, sshClient ssh.SshSession, workingDirectory string, s steps) error {
+	switch s.task {
+	case COPYTOSERVER_TASK:
+		return copyToRemote(file, sshClient, s.param)
+	case COPYFROMSERVER_TASK:
+		return copyFromRemote(file, sshClient, s.param)
+	case SYNC_TASK:
+		return syncToRemote(file, sshClient, s.param)
+	case RUN_TASK:
+		param := strings.Split(s.param["file"].(string), " ")
+		return runCommand(file, sshClient, workingDirectory, param[0], param[1:])
+	case EXEC_TASK:
+		return execCommand(file, sshClient, s.param["command"].(string), nil)
+	case DELAY_TASK:
+		sec := s.param["seconds"].(int)
+		time.Sleep(time.Duration(sec) * time.Second)
+		return nil
+	case TUNNEL_TASK:
+		return openTunnel(file, sshClient, s.param)
+	}
+	return fmt.Errorf("invalid Task : %s", s.task)
+}
+
+func openTunnel(file *deployEvent, sshclient ssh.SshSession, option map[string]any) error {
+	forward, err := tunnelForward(option)
+	if err != nil {
+		return err
+	}
+	l, err := sshclient.Forward(forward)
+	if err != nil {
+		return err
+	}
+	file.tunnels = append(file.tunnels, &tunnel{forward: forward, listener: l})
+	return nil
+}
+
 type sftpFunc func(o io.Writer, src, dst string) error
 
 func remoteSftpFile(src, dst string, fun sftpFunc, file *deployEvent) error {
@@ -179,6 +328,66 @@ func remoteSftpDir(src, dst string, fun sftpFunc, file *deployEvent) error {
 	return fun(eventOutput, src, dst)
 }
 
+func transferOptions(option map[string]any) (ssh.TransferOptions, error) {
+	var opt ssh.TransferOptions
+	var err error
+	opt.Preserve, _ = option["preserve"].(bool)
+	opt.FollowLinks, _ = option["follow_links"].(bool)
+	opt.Resume, _ = option["resume"].(bool)
+	opt.Checksum, _ = option["checksum"].(bool)
+	opt.Delete, _ = option["delete"].(bool)
+	if verify, ok := option["verify"]; ok {
+		if opt.Verify, err = ssh.ParseVerify(fmt.Sprint(verify)); err != nil {
+			return opt, err
+		}
+	}
+	if mode, ok := option["mode"].(string); ok {
+		if opt.Mode, err = ssh.ParseMode(mode); err != nil {
+			return opt, err
+		}
+	}
+	if mode, ok := option["dir_mode"].(string); ok {
+		if opt.DirMode, err = ssh.ParseMode(mode); err != nil {
+			return opt, err
+		}
+	}
+	if opt.Exclude, err = patterns(option, "exclude"); err != nil {
+		return opt, err
+	}
+	if opt.Include, err = patterns(option, "include"); err != nil {
+		return opt, err
+	}
+	if owner, ok := option["owner"]; ok {
+		opt.Owner = fmt.Sprint(owner)
+	}
+	if group, ok := option["group"]; ok {
+		opt.Group = fmt.Sprint(group)
+	}
+	return opt, nil
+}
+
+// patterns returns the glob patterns of a step parameter, a list or a single
+// pattern.
+func patterns(option map[string]any, name string) ([]string, error) {
+	switch v := option[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		list := make([]string, len(v))
+		for i, p := range v {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of patterns", name)
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("%s must be a list of patterns", name)
+}
+
 func copyToRemote(file *deployEvent, sshclient ssh.SshSession, option map[string]any) error {
 	src := option["source"].(string)
 	dst := option["destination"].(string)
@@ -186,6 +395,11 @@ func copyToRemote(file *deployEvent, sshclient ssh.SshSession, option map[string
 	if c, ok := option["parallel"].(bool); ok {
 		sshclient.SetSftpConcurrency(c)
 	}
+	transfer, err := transferOptions(option)
+	if err != nil {
+		return err
+	}
+	sshclient.SetTransferOptions(transfer)
 	if strings.Contains(src, "*") {
 		return fmt.Errorf("%s: wildcard not supported for deploy command", COPYTOSERVER_TASK)
 	}
@@ -208,6 +422,11 @@ func copyFromRemote(file *deployEvent, sshclient ssh.SshSession, option map[stri
 	if c, ok := option["parallel"].(bool); ok {
 		sshclient.SetSftpConcurrency(c)
 	}
+	transfer, err := transferOptions(option)
+	if err != nil {
+		return err
+	}
+	sshclient.SetTransferOptions(transfer)
 	stat, err := sshclient.Stat(src)
 	if err != nil {
 		return err
@@ -221,6 +440,21 @@ func copyFromRemote(file *deployEvent, sshclient ssh.SshSession, option map[stri
 	}
 }
 
+func syncToRemote(file *deployEvent, sshclient ssh.SshSession, option map[string]any) error {
+	src := option["source"].(string)
+	dst := option["destination"].(string)
+	sshclient.SetSftpConcurrency(false)
+	if c, ok := option["parallel"].(bool); ok {
+		sshclient.SetSftpConcurrency(c)
+	}
+	transfer, err := transferOptions(option)
+	if err != nil {
+		return err
+	}
+	sshclient.SetTransferOptions(transfer)
+	return remoteSftpDir(src, dst, sshclient.SyncToRemote, file)
+}
+
 func execCommand(file *deployEvent, sshclient ssh.SshSession, cmd string, args []string) error {
 	eventOutput := progress.NewEventOutputWriter(deployCommand.cmd)
 	file.taskProgress.SetEventOutput(file.event, eventOutput)
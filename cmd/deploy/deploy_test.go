@@ -0,0 +1,215 @@
+package deploy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	cred "github.com/san-gg/mdeploy/pkg/credential"
+	"github.com/san-gg/mdeploy/pkg/progress"
+	"github.com/san-gg/mdeploy/pkg/ssh"
+)
+
+// droppingSession stands in for a session whose connection is lost in the
+// middle of its first transfer. Like the real session, a transfer fails on
+// the directories and files it finds unless it resumes.
+type droppingSession struct {
+	ssh.SshSession
+	connected  bool
+	dropped    bool
+	transfer   ssh.TransferOptions
+	transfers  int
+	reconnects int
+}
+
+type remoteStat struct{ dir bool }
+
+func (s remoteStat) IsDir() bool     { return s.dir }
+func (s remoteStat) IsRegular() bool { return !s.dir }
+
+func (s *droppingSession) Stat(path string) (ssh.FileStat, error) {
+	return remoteStat{dir: filepath.Ext(path) == ""}, nil
+}
+
+func (s *droppingSession) RemoveAll(string) error                   { return nil }
+func (s *droppingSession) Mkdir(string) error                       { return nil }
+func (s *droppingSession) SetSftpConcurrency(bool)                  {}
+func (s *droppingSession) SetTransferOptions(o ssh.TransferOptions) { s.transfer = o }
+func (s *droppingSession) Connected() bool                          { return s.connected }
+func (s *droppingSession) Close()                                   {}
+
+func (s *droppingSession) Reconnect() error {
+	s.reconnects++
+	s.connected = true
+	return nil
+}
+
+func (s *droppingSession) Forward(ssh.Forward) (net.Listener, error) {
+	return nil, errors.New("not supported")
+}
+
+// drop loses the connection the first time it is called.
+func (s *droppingSession) drop() error {
+	s.transfers++
+	if s.dropped {
+		return nil
+	}
+	s.dropped = true
+	s.connected = false
+	return io.ErrUnexpectedEOF
+}
+
+func (s *droppingSession) ReceiveRemoteDir(_ io.Writer, remoteDir, dst string) error {
+	dst = filepath.Join(dst, filepath.Base(remoteDir))
+	if err := os.Mkdir(dst, 0755); err != nil && !s.transfer.Resume {
+		return err
+	}
+	if err := s.drop(); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dst, "app.log"), []byte("log"), 0644)
+}
+
+func (s *droppingSession) ReceiveRemoteFile(_ io.Writer, remoteSrc, dst string) error {
+	if _, err := os.Stat(dst); err == nil && !s.transfer.Resume {
+		return errors.New("file already exists")
+	}
+	if err := os.WriteFile(dst, []byte("par"), 0644); err != nil {
+		return err
+	}
+	if err := s.drop(); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, []byte("partial"), 0644)
+}
+
+type recordedProgress struct {
+	status []progress.Event
+}
+
+func (p *recordedProgress) StartEvent()                                                {}
+func (p *recordedProgress) StopEvent()                                                 {}
+func (p *recordedProgress) SetEventOutput(*progress.Event, progress.EventOutputWriter) {}
+func (p *recordedProgress) UnSetEventOutput(*progress.Event)                           {}
+func (p *recordedProgress) SetStatus(e *progress.Event) {
+	p.status = append(p.status, *e)
+}
+
+func TestRetryAfterLostConnection(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("plain", true, "")
+	deployCommand.cmd = cmd
+
+	tests := []struct {
+		name   string
+		source string
+		dest   func(dir string) string
+		want   string
+	}{
+		{"directory", "/var/log/app", func(dir string) string { return dir }, filepath.Join("app", "app.log")},
+		{"file to file path", "/var/log/app.tar", func(dir string) string { return filepath.Join(dir, "app.tar") }, "app.tar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			session := &droppingSession{connected: true}
+			p := &recordedProgress{}
+			file := &deployEvent{
+				event:        &progress.Event{Id: 1, EventName: "test"},
+				taskProgress: p,
+				yml: &ymlConfig{Steps: []steps{{
+					task:  COPYFROMSERVER_TASK,
+					param: map[string]any{"source": tt.source, "destination": tt.dest(dir)},
+				}}},
+			}
+			runSteps(file, session)
+
+			last := p.status[len(p.status)-1]
+			if last.Status != progress.COMPLETED {
+				t.Fatalf("step not completed: %s", last.Message)
+			}
+			if session.reconnects != 1 || session.transfers != 2 {
+				t.Errorf("got %d reconnects and %d transfers, want 1 and 2", session.reconnects, session.transfers)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestConnectOptionsJumpPasswordProvider(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("Host web2\n  ProxyJump admin@bastion1,admin@bastion2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ssh.LoadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := ssh.Options{PasswordProvider: ssh.PasswordProvider("", "pass show default")}
+	tests := []struct {
+		name   string
+		yml    string
+		target string
+		jump   []string // password source of each hop
+	}{
+		{
+			name: "jump credentials",
+			yml: `
+source: web1
+password_file: /run/secrets/web1
+jump:
+  - source: bastion1
+    password_command: pass show bastion1
+  - source: bastion2
+    password_file: /run/secrets/bastion2
+    password_command: pass show bastion2`,
+			target: "file /run/secrets/web1",
+			jump:   []string{"command pass show bastion1", "file /run/secrets/bastion2, command pass show bastion2"},
+		},
+		{
+			name: "jump destinations",
+			yml: `
+source: web1
+password_command: pass show web1
+jump: [admin@bastion1]`,
+			target: "command pass show web1",
+			jump:   []string{"command pass show default"},
+		},
+		{
+			name: "ProxyJump",
+			yml: `
+source: web2
+password_file: /run/secrets/web2`,
+			target: "file /run/secrets/web2",
+			jump:   []string{"command pass show default", "command pass show default"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c credential
+			if err := yaml.Unmarshal([]byte(tt.yml), &c); err != nil {
+				t.Fatal(err)
+			}
+			options := connectOptions(c, base, config)
+			if got := cred.Source(options.PasswordProvider); got != tt.target {
+				t.Errorf("target password from %q, want %q", got, tt.target)
+			}
+			if len(options.Jump) != len(tt.jump) {
+				t.Fatalf("got %d jump hosts, want %d", len(options.Jump), len(tt.jump))
+			}
+			for i, hop := range options.Jump {
+				if got := cred.Source(hop.PasswordProvider); got != tt.jump[i] {
+					t.Errorf("jump host %s password from %q, want %q", hop.Server, got, tt.jump[i])
+				}
+			}
+		})
+	}
+}
@@ -3,14 +3,69 @@ package deploy
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/san-gg/mdeploy/pkg/progress"
+	"github.com/san-gg/mdeploy/pkg/ssh"
+	"github.com/san-gg/mdeploy/pkg/vault"
 	"gopkg.in/yaml.v3"
 )
 
+const vaultPrefix = "vault:"
+
+var vaultRefRe = regexp.MustCompile(`\$\{vault:([^}]*)\}`)
+
+// secrets holds the vault secrets, loaded before the deployment files are
+// parsed when one of them references the vault.
+var secrets vault.Secrets
+
+// expand replaces $VAR and ${VAR} with the environment and ${vault:name} with
+// a vault secret. It is a single pass, so "$" in a secret is kept as is.
+func expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		if key, ok := strings.CutPrefix(name, vaultPrefix); ok {
+			return secrets[key]
+		}
+		return os.Getenv(name)
+	})
+}
+
+// vaultRefs returns the names of the vault secrets a YAML document
+// references. It rewrites "!vault name" scalars into "${vault:name}" so that
+// they are expanded like the other values.
+func vaultRefs(node *yaml.Node) []string {
+	var refs []string
+	if node.Kind == yaml.ScalarNode {
+		if node.Tag == "!vault" {
+			node.Value = "${" + vaultPrefix + strings.TrimSpace(node.Value) + "}"
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+		for _, m := range vaultRefRe.FindAllStringSubmatch(node.Value, -1) {
+			refs = append(refs, m[1])
+		}
+	}
+	for _, child := range node.Content {
+		refs = append(refs, vaultRefs(child)...)
+	}
+	return refs
+}
+
 type credential struct {
-	source   string
-	username string
-	password string
+	source       string
+	port         int
+	username     string
+	password     string
+	passwordFile string
+	passwordCmd  string
+	keyFile      string
+	passphrase   string
+	answers      map[string]string
+	proxy        string
+	forwardAgent bool
+	jump         []credential
 }
 
 type steps struct {
@@ -26,17 +81,51 @@ type ymlConfig struct {
 }
 
 func (c *credential) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		// jump hosts may be given as user@host[:port]
+		var err error
+		c.username, c.source, c.port, err = ssh.ParseDestination(expand(value.Value))
+		return err
+	}
 	var credential struct {
-		Source   string `yaml:"source"`
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
+		Source       string            `yaml:"source"`
+		Port         string            `yaml:"port"`
+		Username     string            `yaml:"username"`
+		Password     string            `yaml:"password"`
+		PasswordFile string            `yaml:"password_file"`
+		PasswordCmd  string            `yaml:"password_command"`
+		KeyFile      string            `yaml:"key_file"`
+		Passphrase   string            `yaml:"passphrase"`
+		Answers      map[string]string `yaml:"answers"`
+		Proxy        string            `yaml:"proxy"`
+		ForwardAgent bool              `yaml:"forward_agent"`
+		Jump         []credential      `yaml:"jump"`
 	}
 	if err := value.Decode(&credential); err != nil {
 		return err
 	}
-	c.source = os.ExpandEnv(credential.Source)
-	c.username = os.ExpandEnv(credential.Username)
-	c.password = os.ExpandEnv(credential.Password)
+	c.source = expand(credential.Source)
+	if port := expand(credential.Port); port != "" {
+		var err error
+		if c.port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid port: %s", port)
+		}
+	}
+	c.username = expand(credential.Username)
+	c.password = expand(credential.Password)
+	c.passwordFile = expand(credential.PasswordFile)
+	c.passwordCmd = expand(credential.PasswordCmd)
+	c.keyFile = expand(credential.KeyFile)
+	c.passphrase = expand(credential.Passphrase)
+	if len(credential.Answers) > 0 {
+		c.answers = map[string]string{}
+		for prompt, answer := range credential.Answers {
+			c.answers[prompt] = expand(answer)
+		}
+	}
+	c.proxy = expand(credential.Proxy)
+	c.forwardAgent = credential.ForwardAgent
+	c.jump = credential.Jump
 	return nil
 }
 
@@ -54,40 +143,111 @@ func (s *steps) UnmarshalYAML(value *yaml.Node) error {
 	s.param = make(map[string]any)
 	for k, v := range steps.Param {
 		if _, ok := v.(string); ok {
-			v = os.ExpandEnv(v.(string))
+			v = expand(v.(string))
 		}
 		s.param[k] = v
 	}
+	// modes are octal however they are written, 640 as well as 0640
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if k := value.Content[i].Value; k == "mode" || k == "dir_mode" {
+			s.param[k] = expand(value.Content[i+1].Value)
+		}
+	}
 	return nil
 }
 
 var (
 	COPYTOSERVER_TASK   = "COPYTOSERVER"
 	COPYFROMSERVER_TASK = "COPYFROMSERVER"
+	SYNC_TASK           = "SYNC"
 	RUN_TASK            = "RUN"
 	EXEC_TASK           = "EXEC"
 	DELAY_TASK          = "DELAY"
+	TUNNEL_TASK         = "TUNNEL"
 )
 
+// tunnelKinds maps the parameters of the TUNNEL task to the forwarding they
+// open.
+var tunnelKinds = map[string]ssh.ForwardKind{
+	"local":   ssh.LocalForward,
+	"remote":  ssh.RemoteForward,
+	"dynamic": ssh.DynamicForward,
+}
+
+// tunnelForward returns the forwarding of a TUNNEL task, which sets exactly
+// one of local, remote or dynamic.
+func tunnelForward(param map[string]any) (ssh.Forward, error) {
+	var forwards []ssh.Forward
+	for name, kind := range tunnelKinds {
+		spec, ok := param[name]
+		if !ok {
+			continue
+		}
+		f, err := ssh.ParseForward(kind, fmt.Sprint(spec))
+		if err != nil {
+			return f, err
+		}
+		forwards = append(forwards, f)
+	}
+	if len(forwards) != 1 {
+		return ssh.Forward{}, fmt.Errorf("TUNNEL task needs one of local, remote or dynamic parameters")
+	}
+	return forwards[0], nil
+}
+
 func parseYml(file string) (*ymlConfig, error) {
 	var yml ymlConfig
 	bytes, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	if err := yaml.Unmarshal(bytes, &yml); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(bytes, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		return &yml, nil
+	}
+	for _, name := range vaultRefs(&doc) {
+		if _, ok := secrets[name]; !ok {
+			return nil, fmt.Errorf("unknown vault secret %q", name)
+		}
+	}
+	if err := doc.Decode(&yml); err != nil {
 		return nil, err
 	}
 	return &yml, err
 }
 
+// loadVault opens the vault when a deployment file references it. It runs
+// before the progress display starts, as the passphrase may be asked for on
+// the terminal.
+func loadVault(file, passphraseFile string, args []string) error {
+	for _, arg := range args {
+		bytes, err := os.ReadFile(arg)
+		if err != nil {
+			continue
+		}
+		var doc yaml.Node
+		if yaml.Unmarshal(bytes, &doc) != nil || len(vaultRefs(&doc)) == 0 {
+			continue
+		}
+		if secrets, err = vault.Open(file, vault.Passphrase(passphraseFile)); err != nil {
+			return err
+		}
+		for _, value := range secrets {
+			progress.AddSecret(value)
+		}
+		return nil
+	}
+	return nil
+}
+
 func validateYml(yml *ymlConfig) (err error) {
 outer:
 	for _, s := range yml.Steps {
 		switch s.task {
-		case COPYTOSERVER_TASK:
-			fallthrough
-		case COPYFROMSERVER_TASK:
+		case COPYTOSERVER_TASK, COPYFROMSERVER_TASK, SYNC_TASK:
 			if _, ok := s.param["source"].(string); !ok {
 				err = fmt.Errorf("missing source parameter for %s task", s.task)
 				break outer
@@ -96,6 +256,10 @@ outer:
 				err = fmt.Errorf("missing destination parameter for %s task", s.task)
 				break outer
 			}
+			if _, err = transferOptions(s.param); err != nil {
+				err = fmt.Errorf("%s task: %w", s.task, err)
+				break outer
+			}
 		case RUN_TASK:
 			if _, ok := s.param["file"].(string); !ok {
 				err = fmt.Errorf("missing file parameter for %s task", s.task)
@@ -111,6 +275,10 @@ outer:
 				err = fmt.Errorf("missing seconds parameter for DELAY task")
 				break outer
 			}
+		case TUNNEL_TASK:
+			if _, err = tunnelForward(s.param); err != nil {
+				break outer
+			}
 		default:
 			err = fmt.Errorf("invalid Task : %s", s.task)
 			break outer
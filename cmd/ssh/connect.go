@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"strings"
+
+	"github.com/san-gg/mdeploy/pkg/ssh"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type connectOptions struct {
+	port         int
+	identity     string
+	forwardAgent bool
+	jump         []string
+}
+
+func addConnectFlags(flags *pflag.FlagSet, opt *connectOptions) {
+	flags.IntVarP(&opt.port, "port", "p", 0, "server port (default 22)")
+	flags.StringVarP(&opt.identity, "identity", "i", "", "private key file for public key authentication")
+	flags.StringSliceVarP(&opt.jump, "jump", "J", nil, "jump host user@host[:port], repeat or separate with commas for a chain")
+}
+
+func connect(cmd *cobra.Command, host, user string, port int, opt connectOptions, concurrency bool) (ssh.SshSession, error) {
+	options, err := sessionOptions(cmd, host, user, port, opt, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.Connect(options)
+}
+
+// sessionOptions resolves the connection options from the flags and the ssh
+// client config.
+func sessionOptions(cmd *cobra.Command, host, user string, port int, opt connectOptions, concurrency bool) (ssh.Options, error) {
+	options, err := ssh.NewOptions(cmd)
+	if err != nil {
+		return options, err
+	}
+	configFile, err := cmd.Flags().GetString("ssh-config")
+	if err != nil {
+		panic(err)
+	}
+	config, err := ssh.LoadConfig(configFile)
+	if err != nil {
+		return options, err
+	}
+	if port == 0 {
+		port = opt.port
+	}
+	hostKey := options
+	options.Server = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	options.Port = port
+	options.User = user
+	options.KeyFile = opt.identity
+	options.ForwardAgent = opt.forwardAgent
+	options.Interactive = true
+	options.SftpConcurrency = concurrency
+	for _, j := range opt.jump {
+		juser, jhost, jport, err := ssh.ParseDestination(j)
+		if err != nil {
+			return options, err
+		}
+		hop := hostKey
+		hop.Server = jhost
+		hop.Port = jport
+		hop.User = juser
+		hop.KeyFile = opt.identity
+		hop.Interactive = true
+		options.Jump = append(options.Jump, hop)
+	}
+	given := len(options.Jump)
+	config.Apply(&options)
+	// the hops of a ProxyJump entry log in as the ones given with --jump
+	for i := given; i < len(options.Jump); i++ {
+		options.Jump[i].PasswordProvider = hostKey.PasswordProvider
+	}
+	return options, nil
+}
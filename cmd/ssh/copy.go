@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/san-gg/mdeploy/pkg/progress"
@@ -15,6 +16,8 @@ import (
 
 type sftpFunc func(progress io.Writer, src string, dst string) error
 
+var copyOpt connectOptions
+
 func CopyCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "copy SOURCE DESTINATION",
@@ -24,6 +27,17 @@ func CopyCommand() *cobra.Command {
 		RunE:  copyCmd,
 	}
 	cmd.Flags().BoolP("parallel", "P", false, "use parallel copy")
+	cmd.Flags().Bool("preserve", false, "preserve permissions and access and modification times")
+	cmd.Flags().String("mode", "", "permissions of uploaded files, e.g. 0640")
+	cmd.Flags().String("dir-mode", "", "permissions of uploaded directories, e.g. 0755")
+	cmd.Flags().String("owner", "", "user name or uid owning uploaded files")
+	cmd.Flags().String("group", "", "group name or gid owning uploaded files")
+	cmd.Flags().Bool("follow-links", false, "copy what symbolic links in directories point to instead of the links")
+	cmd.Flags().Bool("resume", false, "continue an interrupted copy instead of starting over")
+	cmd.Flags().String("verify", "", "compare the hash of the copied files, e.g. sha256")
+	cmd.Flags().StringArray("exclude", nil, "leave out the files of directories matching a gitignore pattern, repeatable")
+	cmd.Flags().StringArray("include", nil, "copy the files matching a pattern even when excluded, unless a parent directory is, repeatable")
+	addConnectFlags(cmd.Flags(), &copyOpt)
 	return cmd
 }
 
@@ -37,64 +51,90 @@ func copyCmd(cmd *cobra.Command, args []string) error {
 	if _, err := cmd.Flags().GetBool("parallel"); err != nil {
 		panic(err)
 	}
-
-	shost, suser, spwd, spath, serr := parseRemotePath(args[0])
-	dhost, duser, dpwd, dpath, derr := parseRemotePath(args[1])
-
-	if errors.Is(serr, term.CtrlKeyError) || errors.Is(derr, term.CtrlKeyError) {
-		return nil
+	if _, err := cmd.Flags().GetBool("preserve"); err != nil {
+		panic(err)
+	}
+	if _, err := cmd.Flags().GetBool("follow-links"); err != nil {
+		panic(err)
+	}
+	if _, err := cmd.Flags().GetBool("resume"); err != nil {
+		panic(err)
 	}
 
+	shost, suser, sport, spath, serr := parseRemotePath(args[0])
+	dhost, duser, dport, dpath, derr := parseRemotePath(args[1])
+
 	if serr != nil && derr != nil {
 		return fmt.Errorf("remote to remote copy is not supported")
 	}
 
 	if serr == nil {
-		remoteReceive(spath, suser, shost, spwd, args[1], cmd)
+		remoteReceive(spath, suser, shost, sport, args[1], cmd)
 		return nil
 	} else if derr == nil {
-		remoteCopy(args[0], duser, dhost, dpwd, dpath, cmd)
+		remoteCopy(args[0], duser, dhost, dport, dpath, cmd)
 		return nil
 	}
 
 	return fmt.Errorf("source ... target are not valid")
 }
 
-func parseRemotePath(remote string) (host string, user string, password string, path string, err error) {
-	// user@ip:/path
-	parts := strings.Split(remote, ":")
-	if len(parts) != 2 {
+func parseRemotePath(remote string) (host string, user string, port int, path string, err error) {
+	// user@host:/path, user@host:port:/path, user@[::1]:port:/path
+	userHost := strings.SplitN(remote, "@", 2)
+	if len(userHost) != 2 || userHost[0] == "" {
 		err = fmt.Errorf("invalid remote path: %s", remote)
 		return
 	}
-	userHost := strings.Split(parts[0], "@")
-	if len(userHost) != 2 {
+	user = userHost[0]
+	rest := userHost[1]
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]:")
+		if end < 0 {
+			err = fmt.Errorf("invalid remote path: %s", remote)
+			return
+		}
+		host = rest[1:end]
+		rest = rest[end+2:]
+	} else {
+		parts := strings.SplitN(rest, ":", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("invalid remote path: %s", remote)
+			return
+		}
+		host = parts[0]
+		rest = parts[1]
+	}
+	if host == "" {
 		err = fmt.Errorf("invalid remote path: %s", remote)
 		return
 	}
-	user = userHost[0]
-	host = userHost[1]
-	path = parts[1]
-	password, err = term.ReadPassword()
+	if parts := strings.SplitN(rest, ":", 2); len(parts) == 2 {
+		if p, perr := strconv.Atoi(parts[0]); perr == nil && p > 0 && p <= 65535 {
+			port = p
+			rest = parts[1]
+		}
+	}
+	path = rest
 	return
 }
 
-func remoteCopy(src, user, ip, pwd, dst string, cmd *cobra.Command) {
-	trust, _ := cmd.Flags().GetBool("trust")
+func remoteCopy(src, user, ip string, port int, dst string, cmd *cobra.Command) {
 	concurrency, _ := cmd.Flags().GetBool("parallel")
-	sshsession, err := ssh.ConnectWithPassword(ssh.Options{
-		Server:          ip,
-		Port:            22,
-		User:            user,
-		Password:        pwd,
-		TrustServerHost: trust,
-		SftpConcurrency: concurrency,
-	})
-	if err != nil {
+	sshsession, err := connect(cmd, ip, user, port, copyOpt, concurrency)
+	if errors.Is(err, term.CtrlKeyError) {
+		return
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 	defer sshsession.Close()
+	transfer, err := copyTransferOptions(cmd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	sshsession.SetTransferOptions(transfer)
 
 	stat, err := os.Stat(src)
 	if err != nil {
@@ -113,22 +153,22 @@ func remoteCopy(src, user, ip, pwd, dst string, cmd *cobra.Command) {
 	}
 }
 
-func remoteReceive(src, user, ip, pwd, dst string, cmd *cobra.Command) {
-	trust, _ := cmd.Flags().GetBool("trust")
+func remoteReceive(src, user, ip string, port int, dst string, cmd *cobra.Command) {
 	concurrency, _ := cmd.Flags().GetBool("parallel")
-	sshsession, err := ssh.ConnectWithPassword(ssh.Options{
-		Server:          ip,
-		Port:            22,
-		User:            user,
-		Password:        pwd,
-		TrustServerHost: trust,
-		SftpConcurrency: concurrency,
-	})
-	if err != nil {
+	sshsession, err := connect(cmd, ip, user, port, copyOpt, concurrency)
+	if errors.Is(err, term.CtrlKeyError) {
+		return
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 	defer sshsession.Close()
+	transfer, err := copyTransferOptions(cmd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	sshsession.SetTransferOptions(transfer)
 
 	stat, err := sshsession.Stat(src)
 	if err != nil {
@@ -148,6 +188,70 @@ func remoteReceive(src, user, ip, pwd, dst string, cmd *cobra.Command) {
 	}
 }
 
+// transferOptions returns the transfer options of the flags copy and sync
+// share.
+func transferOptions(cmd *cobra.Command) (ssh.TransferOptions, error) {
+	flags := cmd.Flags()
+	var opt ssh.TransferOptions
+	var err error
+	if opt.FollowLinks, err = flags.GetBool("follow-links"); err != nil {
+		return opt, err
+	}
+	if opt.Exclude, err = flags.GetStringArray("exclude"); err != nil {
+		return opt, err
+	}
+	if opt.Include, err = flags.GetStringArray("include"); err != nil {
+		return opt, err
+	}
+	verify, err := flags.GetString("verify")
+	if err != nil {
+		return opt, err
+	}
+	if opt.Verify, err = ssh.ParseVerify(verify); err != nil {
+		return opt, err
+	}
+	mode, err := flags.GetString("mode")
+	if err != nil {
+		return opt, err
+	}
+	if mode != "" {
+		if opt.Mode, err = ssh.ParseMode(mode); err != nil {
+			return opt, err
+		}
+	}
+	dirMode, err := flags.GetString("dir-mode")
+	if err != nil {
+		return opt, err
+	}
+	if dirMode != "" {
+		if opt.DirMode, err = ssh.ParseMode(dirMode); err != nil {
+			return opt, err
+		}
+	}
+	if opt.Owner, err = flags.GetString("owner"); err != nil {
+		return opt, err
+	}
+	if opt.Group, err = flags.GetString("group"); err != nil {
+		return opt, err
+	}
+	return opt, nil
+}
+
+// copyTransferOptions returns the transfer options of the copy flags.
+func copyTransferOptions(cmd *cobra.Command) (ssh.TransferOptions, error) {
+	opt, err := transferOptions(cmd)
+	if err != nil {
+		return opt, err
+	}
+	if opt.Preserve, err = cmd.Flags().GetBool("preserve"); err != nil {
+		return opt, err
+	}
+	if opt.Resume, err = cmd.Flags().GetBool("resume"); err != nil {
+		return opt, err
+	}
+	return opt, nil
+}
+
 func remoteSftp(src, dst string, fun sftpFunc, output io.Writer) error {
 	if fun == nil {
 		panic("fileFunc is nil")
@@ -0,0 +1,43 @@
+package ssh
+
+import "testing"
+
+func TestParseRemotePath(t *testing.T) {
+	tests := []struct {
+		remote string
+		host   string
+		user   string
+		port   int
+		path   string
+		ok     bool
+	}{
+		{"deploy@web1:/srv/app", "web1", "deploy", 0, "/srv/app", true},
+		{"deploy@web1:", "web1", "deploy", 0, "", true},
+		{"deploy@web1:app/config.yml", "web1", "deploy", 0, "app/config.yml", true},
+		{"deploy@web1:2222:/srv/app", "web1", "deploy", 2222, "/srv/app", true},
+		{"deploy@web1:2222:", "web1", "deploy", 2222, "", true},
+		{"deploy@web1:C:/app", "web1", "deploy", 0, "C:/app", true},
+		{"deploy@web1:70000:/srv", "web1", "deploy", 0, "70000:/srv", true},
+		{"deploy@[::1]:/srv/app", "::1", "deploy", 0, "/srv/app", true},
+		{"deploy@[::1]:2222:/srv/app", "::1", "deploy", 2222, "/srv/app", true},
+		{"deploy@[fe80::1%eth0]:22:/srv", "fe80::1%eth0", "deploy", 22, "/srv", true},
+		{"/srv/app", "", "", 0, "", false},
+		{"C:/app", "", "", 0, "", false},
+		{"@web1:/srv", "", "", 0, "", false},
+		{"deploy@web1", "", "", 0, "", false},
+		{"deploy@:/srv", "", "", 0, "", false},
+		{"deploy@[::1]/srv", "", "", 0, "", false},
+		{"deploy@[]:/srv", "", "", 0, "", false},
+	}
+	for _, tt := range tests {
+		host, user, port, path, err := parseRemotePath(tt.remote)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseRemotePath(%q): got error %v", tt.remote, err)
+			continue
+		}
+		if tt.ok && (host != tt.host || user != tt.user || port != tt.port || path != tt.path) {
+			t.Errorf("parseRemotePath(%q) = %q, %q, %d, %q, want %q, %q, %d, %q",
+				tt.remote, host, user, port, path, tt.host, tt.user, tt.port, tt.path)
+		}
+	}
+}
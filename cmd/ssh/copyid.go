@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/san-gg/mdeploy/pkg/ssh"
+	"github.com/san-gg/mdeploy/pkg/term"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type copyIDOptions struct {
+	host string
+	user string
+	key  string
+	connectOptions
+}
+
+var copyIDOpt copyIDOptions
+
+// defaultPublicKeys are tried in order when no --key is given.
+var defaultPublicKeys = []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"}
+
+func CopyIDCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "copy-id",
+		Short:        "Install a public key on a remote server",
+		Long:         "Log in with a password, add a public key to ~/.ssh/authorized_keys and check that the key can log in.",
+		Args:         cobra.NoArgs,
+		RunE:         copyIDCmd,
+		SilenceUsage: true,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&copyIDOpt.host, "host", "H", "", "server host")
+	flags.StringVarP(&copyIDOpt.user, "user", "U", "", "username")
+	flags.StringVar(&copyIDOpt.key, "key", "", "public key file (default ~/.ssh/id_ed25519.pub, id_ecdsa.pub or id_rsa.pub)")
+	flags.StringVarP(&copyIDOpt.identity, "identity", "i", "", "private key file for the jump hosts, the server is logged in to with the password")
+	flags.IntVarP(&copyIDOpt.port, "port", "p", 0, "server port (default 22)")
+	flags.StringSliceVarP(&copyIDOpt.jump, "jump", "J", nil, "jump host user@host[:port], repeat or separate with commas for a chain")
+	return cmd
+}
+
+func publicKeyFile() (string, error) {
+	if copyIDOpt.key != "" {
+		return copyIDOpt.key, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range defaultPublicKeys {
+		file := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", errors.New("no public key found in ~/.ssh, use --key")
+}
+
+// copyIDCmd returns its errors so that a failed installation exits with a
+// non-zero status.
+func copyIDCmd(cmd *cobra.Command, args []string) error {
+	keyFile, err := publicKeyFile()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(keyFile, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		keyFile = filepath.Join(home, keyFile[2:])
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	key, line, err := ssh.ParsePublicKey(data)
+	if err != nil {
+		return fmt.Errorf("invalid public key %s: %w", keyFile, err)
+	}
+
+	options, err := sessionOptions(cmd, copyIDOpt.host, copyIDOpt.user, 0, copyIDOpt.connectOptions, false)
+	if err != nil {
+		return err
+	}
+	// --identity only reaches the jump hosts
+	options.KeyFile = ""
+	sshsession, err := ssh.ConnectWithPassword(options)
+	if errors.Is(err, term.CtrlKeyError) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	added, err := sshsession.InstallPublicKey(line)
+	sshsession.Close()
+	if err != nil {
+		return err
+	}
+	destination := options.User + "@" + options.Server
+	if added {
+		fmt.Printf("added %s %s to %s\n", key.Type(), gossh.FingerprintSHA256(key), destination)
+	} else {
+		fmt.Printf("%s %s is already authorized on %s\n", key.Type(), gossh.FingerprintSHA256(key), destination)
+	}
+
+	if err := ssh.VerifyPublicKey(options, key, strings.TrimSuffix(keyFile, ".pub")); err != nil {
+		return fmt.Errorf("key authentication to %s failed: %w", destination, err)
+	}
+	fmt.Printf("key authentication to %s works\n", destination)
+	return nil
+}
@@ -1,9 +1,11 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/san-gg/mdeploy/pkg/progress"
 	"github.com/san-gg/mdeploy/pkg/ssh"
 	"github.com/san-gg/mdeploy/pkg/term"
 	"github.com/spf13/cobra"
@@ -12,6 +14,7 @@ import (
 type execOptions struct {
 	host string
 	user string
+	connectOptions
 }
 
 var execOpt execOptions
@@ -27,28 +30,16 @@ func ExecCommand() *cobra.Command {
 	flags := cmd.Flags()
 	flags.StringVarP(&execOpt.host, "host", "H", "", "server host")
 	flags.StringVarP(&execOpt.user, "user", "U", "", "username")
+	addConnectFlags(flags, &execOpt.connectOptions)
+	flags.BoolVarP(&execOpt.forwardAgent, "forward-agent", "A", false, "forward the local ssh-agent to the remote command")
 	return cmd
 }
 
 func execCmd(cmd *cobra.Command, args []string) error {
-	trust, err := cmd.Flags().GetBool("trust")
-	if err != nil {
-		panic(err)
-	}
-	pwd, err := term.ReadPassword()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "failed to read password:", err)
+	sshsession, err := connect(cmd, execOpt.host, execOpt.user, 0, execOpt.connectOptions, false)
+	if errors.Is(err, term.CtrlKeyError) {
 		return nil
-	}
-	sshsession, err := ssh.ConnectWithPassword(ssh.Options{
-		Server:          execOpt.host,
-		Port:            22,
-		User:            execOpt.user,
-		Password:        string(pwd),
-		TrustServerHost: trust,
-		SftpConcurrency: false,
-	})
-	if err != nil {
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return nil
 	}
@@ -69,6 +60,6 @@ type NewLineWriter struct{}
 
 func (w NewLineWriter) Write(p []byte) (n int, err error) {
 	n = len(p)
-	fmt.Println(string(p))
+	fmt.Println(progress.Redact(string(p)))
 	return
 }
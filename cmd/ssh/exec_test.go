@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/san-gg/mdeploy/pkg/credential"
+	"github.com/san-gg/mdeploy/pkg/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// noAuthServer starts an SSH server letting every client in without
+// authentication and refusing every channel.
+func noAuthServer(t *testing.T) (string, int) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := gossh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go gossh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(gossh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+// stdout returns what f prints.
+func stdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = orig
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestUnusedPasswordsMasked(t *testing.T) {
+	host, port := noAuthServer(t)
+	asked := false
+	opt := ssh.Options{
+		Server:        host,
+		Port:          port,
+		User:          "deploy",
+		Password:      "target-s3cret",
+		HostKeyPolicy: ssh.HostKeyOff,
+		Proxy:         "none",
+		PasswordProvider: credential.ProviderFunc(func() (string, error) {
+			asked = true
+			return "", nil
+		}),
+		Jump: []ssh.Options{{
+			Server:        host,
+			Port:          port,
+			User:          "admin",
+			Password:      "bastion-s3cret",
+			HostKeyPolicy: ssh.HostKeyOff,
+			Proxy:         "none",
+		}},
+	}
+	// the jump host lets the client in without a password and refuses to
+	// forward the connection to the target
+	if session, err := ssh.Connect(opt); err == nil {
+		session.Close()
+	}
+	if asked {
+		t.Fatal("password provider asked, the test must log in without a password")
+	}
+
+	out := stdout(t, func() {
+		NewLineWriter{}.Write([]byte("echo target-s3cret bastion-s3cret"))
+	})
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("password printed: %q", out)
+	}
+}
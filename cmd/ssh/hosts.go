@@ -0,0 +1,239 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/san-gg/mdeploy/pkg/ssh"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type hostsOptions struct {
+	port        int
+	fingerprint string
+	hash        bool
+	timeout     time.Duration
+	jump        []string
+}
+
+var hostsOpt hostsOptions
+
+func HostsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hosts",
+		Short: "Manage trusted SSH host keys",
+		Long:  "List, scan, add, remove and verify the host keys in the known hosts file.",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List known hosts with their SHA256 fingerprints",
+		Args:  cobra.NoArgs,
+		RunE:  hostsList,
+	}
+	scan := &cobra.Command{
+		Use:   "scan HOST",
+		Short: "Fetch and show the host keys of a server without logging in",
+		Args:  cobra.ExactArgs(1),
+		RunE:  hostsScan,
+	}
+	add := &cobra.Command{
+		Use:   "add HOST",
+		Short: "Scan a server and trust its host keys",
+		Args:  cobra.ExactArgs(1),
+		RunE:  hostsAdd,
+	}
+	add.Flags().StringVar(&hostsOpt.fingerprint, "fingerprint", "", "only trust the key with this SHA256 fingerprint")
+	add.Flags().BoolVar(&hostsOpt.hash, "hash", false, "hash the host name in the known hosts file")
+	remove := &cobra.Command{
+		Use:   "remove HOST",
+		Short: "Remove the keys of a host from the known hosts file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  hostsRemove,
+	}
+	verify := &cobra.Command{
+		Use:   "verify HOST FINGERPRINT",
+		Short: "Check that a server offers the host key with the given fingerprint",
+		Args:  cobra.ExactArgs(2),
+		RunE:  hostsVerify,
+	}
+	for _, c := range []*cobra.Command{scan, add, remove, verify} {
+		c.Flags().IntVarP(&hostsOpt.port, "port", "p", 0, "server port (default 22)")
+	}
+	for _, c := range []*cobra.Command{scan, add, verify} {
+		c.Flags().DurationVar(&hostsOpt.timeout, "timeout", 0, "connection timeout (default --connect-timeout)")
+		c.Flags().StringSliceVarP(&hostsOpt.jump, "jump", "J", nil, "jump host user@host[:port], repeat or separate with commas for a chain")
+	}
+	// errors are returned rather than printed so that scripts seeding trust
+	// get a non-zero exit status
+	for _, c := range []*cobra.Command{list, scan, add, remove, verify} {
+		c.SilenceUsage = true
+	}
+	cmd.AddCommand(list, scan, add, remove, verify)
+	return cmd
+}
+
+func knownHostsFlag(cmd *cobra.Command) string {
+	file, err := cmd.Flags().GetString("known-hosts")
+	if err != nil {
+		panic(err)
+	}
+	return file
+}
+
+// hostOptions resolves HOST through the ssh client config the same way
+// connections do, so keys are looked up and recorded under the same name.
+func hostOptions(cmd *cobra.Command, host string) (ssh.Options, error) {
+	options, err := ssh.NewOptions(cmd)
+	if err != nil {
+		return options, err
+	}
+	configFile, err := cmd.Flags().GetString("ssh-config")
+	if err != nil {
+		panic(err)
+	}
+	config, err := ssh.LoadConfig(configFile)
+	if err != nil {
+		return options, err
+	}
+	_, options.Server, options.Port, err = ssh.ParseDestination(host)
+	if err != nil {
+		return options, err
+	}
+	if hostsOpt.port != 0 {
+		options.Port = hostsOpt.port
+	}
+	if hostsOpt.timeout != 0 {
+		options.ConnectTimeout = hostsOpt.timeout
+	}
+	hostKey := options
+	for _, j := range hostsOpt.jump {
+		juser, jhost, jport, err := ssh.ParseDestination(j)
+		if err != nil {
+			return options, err
+		}
+		hop := hostKey
+		hop.Server = jhost
+		hop.Port = jport
+		hop.User = juser
+		hop.Interactive = true
+		options.Jump = append(options.Jump, hop)
+	}
+	config.Apply(&options)
+	return options, nil
+}
+
+func hostAddress(opt ssh.Options) string {
+	return net.JoinHostPort(opt.Server, strconv.Itoa(opt.Port))
+}
+
+func normalizeFingerprint(fp string) string {
+	return "SHA256:" + strings.TrimRight(strings.TrimPrefix(fp, "SHA256:"), "=")
+}
+
+func scanHost(cmd *cobra.Command, host string) (ssh.Options, []gossh.PublicKey, error) {
+	options, err := hostOptions(cmd, host)
+	if err != nil {
+		return options, nil, err
+	}
+	keys, err := ssh.ScanHostKeys(options)
+	return options, keys, err
+}
+
+func hostsList(cmd *cobra.Command, args []string) error {
+	hosts, err := ssh.ListKnownHosts(knownHostsFlag(cmd))
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		names := strings.Join(h.Hosts, ",")
+		if h.Marker != "" {
+			names = "@" + h.Marker + " " + names
+		}
+		fmt.Printf("%s %s %s\n", names, h.Key.Type(), h.Fingerprint())
+	}
+	return nil
+}
+
+func hostsScan(cmd *cobra.Command, args []string) error {
+	options, keys, err := scanHost(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		fmt.Printf("%s %s %s\n", hostAddress(options), key.Type(), gossh.FingerprintSHA256(key))
+	}
+	return nil
+}
+
+func hostsAdd(cmd *cobra.Command, args []string) error {
+	options, keys, err := scanHost(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	if hostsOpt.fingerprint != "" {
+		want := normalizeFingerprint(hostsOpt.fingerprint)
+		var matched []gossh.PublicKey
+		for _, key := range keys {
+			if gossh.FingerprintSHA256(key) == want {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) == 0 {
+			return fmt.Errorf("%s does not offer a host key with fingerprint %s", hostAddress(options), want)
+		}
+		keys = matched
+	}
+	hash := hostsOpt.hash || options.HashKnownHosts
+	for _, key := range keys {
+		added, err := ssh.AddKnownHost(options.KnownHostsFile, hostAddress(options), key, hash)
+		if err != nil {
+			return err
+		}
+		state := "added"
+		if !added {
+			state = "already known"
+		}
+		fmt.Printf("%s %s %s %s\n", hostAddress(options), key.Type(), gossh.FingerprintSHA256(key), state)
+	}
+	return nil
+}
+
+func hostsRemove(cmd *cobra.Command, args []string) error {
+	options, err := hostOptions(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	removed, err := ssh.RemoveKnownHost(options.KnownHostsFile, hostAddress(options))
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("%s is not a known host", hostAddress(options))
+	}
+	fmt.Printf("removed %d key(s) for %s\n", removed, hostAddress(options))
+	return nil
+}
+
+func hostsVerify(cmd *cobra.Command, args []string) error {
+	options, keys, err := scanHost(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	want := normalizeFingerprint(args[1])
+	for _, key := range keys {
+		if gossh.FingerprintSHA256(key) == want {
+			fmt.Printf("%s %s %s verified\n", hostAddress(options), key.Type(), want)
+			return nil
+		}
+	}
+	var offered []string
+	for _, key := range keys {
+		offered = append(offered, fmt.Sprintf("  offered: %s %s", key.Type(), gossh.FingerprintSHA256(key)))
+	}
+	return fmt.Errorf("%s does not offer a host key with fingerprint %s\n%s",
+		hostAddress(options), want, strings.Join(offered, "\n"))
+}
@@ -1,13 +1,13 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
-	"github.com/san-gg/mdeploy/pkg/ssh"
 	"github.com/san-gg/mdeploy/pkg/term"
 	"github.com/spf13/cobra"
 )
@@ -15,6 +15,7 @@ import (
 type runOptions struct {
 	host string
 	user string
+	connectOptions
 }
 
 var runOpt runOptions
@@ -30,28 +31,16 @@ func RunCommand() *cobra.Command {
 	flags := cmd.Flags()
 	flags.StringVarP(&runOpt.host, "host", "H", "", "server host")
 	flags.StringVarP(&runOpt.user, "user", "U", "", "username")
+	addConnectFlags(flags, &runOpt.connectOptions)
+	flags.BoolVarP(&runOpt.forwardAgent, "forward-agent", "A", false, "forward the local ssh-agent to the remote command")
 	return cmd
 }
 
 func runCmd(cmd *cobra.Command, args []string) error {
-	trust, err := cmd.Flags().GetBool("trust")
-	if err != nil {
-		panic(err)
-	}
-	pwd, err := term.ReadPassword()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "failed to read password:", err)
+	sshsession, err := connect(cmd, runOpt.host, runOpt.user, 0, runOpt.connectOptions, false)
+	if errors.Is(err, term.CtrlKeyError) {
 		return nil
-	}
-	sshsession, err := ssh.ConnectWithPassword(ssh.Options{
-		Server:          runOpt.host,
-		Port:            22,
-		User:            runOpt.user,
-		Password:        string(pwd),
-		TrustServerHost: trust,
-		SftpConcurrency: false,
-	})
-	if err != nil {
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return nil
 	}
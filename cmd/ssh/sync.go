@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/san-gg/mdeploy/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+var syncOpt connectOptions
+
+func SyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync SOURCE DESTINATION",
+		Short: "Synchronize directories between local and remote servers",
+		Long:  "Make the destination directory a copy of the source directory, copying only the files that changed.",
+		Args:  cobra.ExactArgs(2),
+		RunE:  syncCmd,
+		// errors are returned rather than printed so that a failed sync
+		// exits with a non-zero status
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolP("parallel", "P", false, "use parallel copy")
+	cmd.Flags().Bool("checksum", false, "compare the sha256 of files of the same size instead of their modification times")
+	cmd.Flags().Bool("delete", false, "remove destination files missing from the source")
+	cmd.Flags().String("mode", "", "permissions of uploaded files, e.g. 0640")
+	cmd.Flags().String("dir-mode", "", "permissions of uploaded directories, e.g. 0755")
+	cmd.Flags().String("owner", "", "user name or uid owning uploaded files")
+	cmd.Flags().String("group", "", "group name or gid owning uploaded files")
+	cmd.Flags().Bool("follow-links", false, "copy what symbolic links in directories point to instead of the links")
+	cmd.Flags().String("verify", "", "compare the hash of the copied files, e.g. sha256")
+	cmd.Flags().StringArray("exclude", nil, "leave out the files matching a gitignore pattern, repeatable")
+	cmd.Flags().StringArray("include", nil, "sync the files matching a pattern even when excluded, unless a parent directory is, repeatable")
+	addConnectFlags(cmd.Flags(), &syncOpt)
+	return cmd
+}
+
+func syncCmd(cmd *cobra.Command, args []string) error {
+	shost, suser, sport, spath, serr := parseRemotePath(args[0])
+	dhost, duser, dport, dpath, derr := parseRemotePath(args[1])
+
+	if serr == nil && derr == nil {
+		return fmt.Errorf("remote to remote sync is not supported")
+	} else if serr != nil && derr != nil {
+		return fmt.Errorf("source or destination must be remote")
+	}
+
+	transfer, err := transferOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if transfer.Checksum, err = cmd.Flags().GetBool("checksum"); err != nil {
+		return err
+	}
+	if transfer.Delete, err = cmd.Flags().GetBool("delete"); err != nil {
+		return err
+	}
+	concurrency, err := cmd.Flags().GetBool("parallel")
+	if err != nil {
+		return err
+	}
+	host, user, port := dhost, duser, dport
+	if serr == nil {
+		host, user, port = shost, suser, sport
+	}
+	sshsession, err := connect(cmd, host, user, port, syncOpt, concurrency)
+	if errors.Is(err, term.CtrlKeyError) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer sshsession.Close()
+	sshsession.SetTransferOptions(transfer)
+
+	if serr == nil {
+		return sshsession.SyncFromRemote(os.Stdout, spath, args[1])
+	}
+	return sshsession.SyncToRemote(os.Stdout, args[0], dpath)
+}
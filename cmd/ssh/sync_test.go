@@ -0,0 +1,57 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSyncErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"dir", "other"}, "must be remote"},
+		{[]string{"deploy@web1:/srv", "deploy@web2:/srv"}, "remote to remote"},
+		{[]string{"--mode", "0999", "dir", "deploy@web1:/srv"}, "invalid mode"},
+		{[]string{"--dir-mode", "rwx", "dir", "deploy@web1:/srv"}, "invalid mode"},
+		{[]string{"--verify", "md5", "dir", "deploy@web1:/srv"}, "unsupported verify hash"},
+	}
+	for _, tt := range tests {
+		cmd := SyncCommand()
+		cmd.SetArgs(tt.args)
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("sync %q: got error %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestTransferOptions(t *testing.T) {
+	sync := SyncCommand()
+	if err := sync.ParseFlags([]string{"--mode", "0640", "--exclude", "*.log", "--follow-links"}); err != nil {
+		t.Fatal(err)
+	}
+	opt, err := transferOptions(sync)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if opt.Mode != 0o640 || len(opt.Exclude) != 1 || !opt.FollowLinks {
+		t.Errorf("sync: got %+v", opt)
+	}
+
+	cp := CopyCommand()
+	if err := cp.ParseFlags([]string{"--resume", "--preserve", "--dir-mode", "0755"}); err != nil {
+		t.Fatal(err)
+	}
+	if opt, err = copyTransferOptions(cp); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if !opt.Resume || !opt.Preserve || opt.DirMode != 0o755 {
+		t.Errorf("copy: got %+v", opt)
+	}
+	if _, err := copyTransferOptions(sync); err == nil {
+		t.Error("sync has no resume flag, want an error")
+	}
+}
@@ -0,0 +1,111 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/san-gg/mdeploy/pkg/ssh"
+	"github.com/san-gg/mdeploy/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+type tunnelOptions struct {
+	host    string
+	user    string
+	local   []string
+	remote  []string
+	dynamic []string
+	connectOptions
+}
+
+var tunnelOpt tunnelOptions
+
+func TunnelCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tunnel",
+		Short: "Forward ports through a remote server",
+		Long:  "Forward local ports to addresses reachable from the server, server ports to local addresses, or run a local SOCKS5 proxy through the server, until interrupted.",
+		Args:  cobra.NoArgs,
+		RunE:  tunnelCmd,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&tunnelOpt.host, "host", "H", "", "server host")
+	flags.StringVarP(&tunnelOpt.user, "user", "U", "", "username")
+	addConnectFlags(flags, &tunnelOpt.connectOptions)
+	flags.StringArrayVarP(&tunnelOpt.local, "local", "L", nil, "forward [bind_address:]port:host:hostport from this machine to host:hostport as seen by the server")
+	flags.StringArrayVarP(&tunnelOpt.remote, "remote", "R", nil, "forward [bind_address:]port:host:hostport from the server to host:hostport as seen by this machine")
+	flags.StringArrayVarP(&tunnelOpt.dynamic, "dynamic", "D", nil, "run a SOCKS5 proxy on [bind_address:]port connecting from the server")
+	return cmd
+}
+
+func parseForwards() ([]ssh.Forward, error) {
+	var forwards []ssh.Forward
+	for _, flag := range []struct {
+		kind  ssh.ForwardKind
+		specs []string
+	}{
+		{ssh.LocalForward, tunnelOpt.local},
+		{ssh.RemoteForward, tunnelOpt.remote},
+		{ssh.DynamicForward, tunnelOpt.dynamic},
+	} {
+		for _, spec := range flag.specs {
+			f, err := ssh.ParseForward(flag.kind, spec)
+			if err != nil {
+				return nil, err
+			}
+			forwards = append(forwards, f)
+		}
+	}
+	if len(forwards) == 0 {
+		return nil, errors.New("nothing to forward, use -L, -R or -D")
+	}
+	return forwards, nil
+}
+
+func tunnelCmd(cmd *cobra.Command, args []string) error {
+	forwards, err := parseForwards()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	sshsession, err := connect(cmd, tunnelOpt.host, tunnelOpt.user, 0, tunnelOpt.connectOptions, false)
+	if errors.Is(err, term.CtrlKeyError) {
+		return nil
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	defer sshsession.Close()
+
+	for _, f := range forwards {
+		l, err := sshsession.Forward(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to forward", f.String()+":", err)
+			return nil
+		}
+		defer l.Close()
+		f.Listen = l.Addr().String()
+		fmt.Println("forwarding", f.String())
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+			if !sshsession.Connected() {
+				fmt.Fprintln(os.Stderr, "connection to server lost")
+				return nil
+			}
+		}
+	}
+}
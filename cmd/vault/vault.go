@@ -0,0 +1,252 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/san-gg/mdeploy/pkg/term"
+	"github.com/san-gg/mdeploy/pkg/vault"
+	"github.com/spf13/cobra"
+)
+
+func VaultCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vault",
+		Short: "Manage the encrypted secrets vault",
+		Long: `The vault is a YAML file mapping secret names to values, encrypted with a passphrase.
+Deployment files reference its secrets as "!vault name" or "${vault:name}".
+The passphrase is read from MDEPLOY_VAULT_PASSWORD, --vault-password-file or the terminal.`,
+	}
+	encrypt := &cobra.Command{
+		Use:   "encrypt [FILE]",
+		Short: "Encrypt a plain YAML secrets file in place",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  vaultEncrypt,
+	}
+	decrypt := &cobra.Command{
+		Use:   "decrypt [FILE]",
+		Short: "Decrypt a vault in place",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  vaultDecrypt,
+	}
+	edit := &cobra.Command{
+		Use:   "edit [FILE]",
+		Short: "Edit a vault with $EDITOR, creating it when missing",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  vaultEdit,
+	}
+	view := &cobra.Command{
+		Use:   "view [FILE]",
+		Short: "Print the decrypted secrets",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  vaultView,
+	}
+	for _, c := range []*cobra.Command{encrypt, decrypt, edit, view} {
+		c.SilenceUsage = true
+	}
+	cmd.AddCommand(encrypt, decrypt, edit, view)
+	return cmd
+}
+
+// vaultFile returns FILE or the --vault flag.
+func vaultFile(cmd *cobra.Command, args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	file, err := cmd.Flags().GetString("vault")
+	if err != nil {
+		panic(err)
+	}
+	return file
+}
+
+func passphraseFile(cmd *cobra.Command) string {
+	file, err := cmd.Flags().GetString("vault-password-file")
+	if err != nil {
+		panic(err)
+	}
+	return file
+}
+
+// newPassphrase asks for the passphrase of a vault being created, twice when
+// it is typed on the terminal.
+func newPassphrase(cmd *cobra.Command) (string, error) {
+	if os.Getenv(vault.PassphraseEnv) != "" || passphraseFile(cmd) != "" {
+		return vault.Passphrase(passphraseFile(cmd)).Secret()
+	}
+	if !term.IsTerminal() {
+		return "", fmt.Errorf("no vault passphrase, set %s or --vault-password-file", vault.PassphraseEnv)
+	}
+	pass, err := term.ReadSecret("New vault passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := term.ReadSecret("Confirm vault passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// open decrypts a vault and returns its plaintext with the passphrase used.
+func open(cmd *cobra.Command, file string) ([]byte, string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+	pass, err := vault.Passphrase(passphraseFile(cmd)).Secret()
+	if err != nil {
+		return nil, "", err
+	}
+	if pass == "" {
+		return nil, "", fmt.Errorf("no vault passphrase, set %s or --vault-password-file", vault.PassphraseEnv)
+	}
+	plaintext, err := vault.Decrypt(data, pass)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", file, err)
+	}
+	return plaintext, pass, nil
+}
+
+func vaultEncrypt(cmd *cobra.Command, args []string) error {
+	file := vaultFile(cmd, args)
+	plaintext, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if vault.IsEncrypted(plaintext) {
+		return fmt.Errorf("%s is already encrypted", file)
+	}
+	if _, err := vault.Parse(plaintext); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	pass, err := newPassphrase(cmd)
+	if err != nil {
+		return err
+	}
+	data, err := vault.Encrypt(plaintext, pass)
+	if err != nil {
+		return err
+	}
+	if err := vault.WriteFile(file, data); err != nil {
+		return err
+	}
+	fmt.Println("encrypted", file)
+	return nil
+}
+
+func vaultDecrypt(cmd *cobra.Command, args []string) error {
+	file := vaultFile(cmd, args)
+	plaintext, _, err := open(cmd, file)
+	if err != nil {
+		return err
+	}
+	if err := vault.WriteFile(file, plaintext); err != nil {
+		return err
+	}
+	fmt.Println("decrypted", file)
+	return nil
+}
+
+func vaultView(cmd *cobra.Command, args []string) error {
+	plaintext, _, err := open(cmd, vaultFile(cmd, args))
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(plaintext)
+	return nil
+}
+
+func editor() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if e := strings.Fields(os.Getenv(name)); len(e) > 0 {
+			return e
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// vaultEdit decrypts the vault into a temporary file only readable by the
+// user, which is removed once the editor exits or mdeploy is terminated.
+func vaultEdit(cmd *cobra.Command, args []string) error {
+	file := vaultFile(cmd, args)
+	var plaintext []byte
+	var pass string
+	var err error
+	if _, statErr := os.Stat(file); os.IsNotExist(statErr) {
+		if pass, err = newPassphrase(cmd); err != nil {
+			return err
+		}
+		plaintext = []byte("# secret_name: value\n")
+	} else if plaintext, pass, err = open(cmd, file); err != nil {
+		return err
+	}
+
+	// created 0700, the directory keeps the file and the swap files the
+	// editor writes next to it from other users, and is removed with them
+	dir, err := os.MkdirTemp("", "mdeploy-vault-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	// the editor gets the interrupt key, mdeploy waits for it to exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+	go func() {
+		for sig := range signals {
+			if sig != os.Interrupt {
+				os.RemoveAll(dir)
+				os.Exit(1)
+			}
+		}
+	}()
+	tmp := filepath.Join(dir, "vault.yml")
+	if err := os.WriteFile(tmp, plaintext, 0600); err != nil {
+		return err
+	}
+
+	e := editor()
+	edit := exec.Command(e[0], append(e[1:], tmp)...)
+	edit.Stdin, edit.Stdout, edit.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := edit.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", e[0], err)
+	}
+	edited, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plaintext) {
+		fmt.Println("no changes to", file)
+		return nil
+	}
+	if _, err := vault.Parse(edited); err != nil {
+		return fmt.Errorf("not saved, %w", err)
+	}
+	data, err := vault.Encrypt(edited, pass)
+	if err != nil {
+		return err
+	}
+	if err := vault.WriteFile(file, data); err != nil {
+		return err
+	}
+	fmt.Println("saved", file)
+	return nil
+}
@@ -9,6 +9,8 @@ import (
 
 	"github.com/san-gg/mdeploy/cmd/deploy"
 	"github.com/san-gg/mdeploy/cmd/ssh"
+	"github.com/san-gg/mdeploy/cmd/vault"
+	"github.com/san-gg/mdeploy/pkg/progress"
 
 	"github.com/spf13/cobra"
 )
@@ -39,14 +41,31 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	progress.AddSecretEnv()
 	rootCmd.AddCommand(
 		deploy.DeployCommand(),
 		ssh.CopyCommand(),
+		ssh.SyncCommand(),
 		ssh.ExecCommand(),
 		ssh.RunCommand(),
+		ssh.CopyIDCommand(),
+		ssh.TunnelCommand(),
+		ssh.HostsCommand(),
+		vault.VaultCommand(),
 	)
 	rootCmd.PersistentFlags().Bool("plain", false, "print plain output")
 	rootCmd.PersistentFlags().BoolP("trust", "T", false, "trust SSH server host key")
+	rootCmd.PersistentFlags().MarkDeprecated("trust", "use --host-key-policy=accept-new instead")
+	rootCmd.PersistentFlags().String("host-key-policy", "strict", "host key checking: strict, accept-new or off")
+	rootCmd.PersistentFlags().String("known-hosts", "", "known hosts file (default ~/.ssh/known_hosts)")
+	rootCmd.PersistentFlags().Duration("connect-timeout", 0, "SSH connection timeout (default 30s)")
+	rootCmd.PersistentFlags().Duration("keepalive", 0, "interval between SSH keepalives, 0 disables them (default 30s)")
+	rootCmd.PersistentFlags().String("proxy", "", "SOCKS5 or HTTP CONNECT proxy URL, \"none\" ignores ALL_PROXY and HTTPS_PROXY")
+	rootCmd.PersistentFlags().String("password-file", "", "read the SSH password from the first line of a file")
+	rootCmd.PersistentFlags().String("password-command", "", "run a local command printing the SSH password, e.g. \"pass show deploy\"")
+	rootCmd.PersistentFlags().String("vault", "vault.yml", "encrypted secrets vault referenced by deployment files")
+	rootCmd.PersistentFlags().String("vault-password-file", "", "read the vault passphrase from the first line of a file")
+	rootCmd.PersistentFlags().String("ssh-config", "", "OpenSSH client config file (default ~/.ssh/config)")
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	if err := rootCmd.Execute(); err != nil {
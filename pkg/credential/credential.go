@@ -0,0 +1,178 @@
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/san-gg/mdeploy/pkg/term"
+)
+
+// PasswordEnv is the environment variable holding a password for servers
+// that ask for one.
+const PasswordEnv = "MDEPLOY_PASSWORD"
+
+// Provider supplies a secret such as a password. An empty secret means the
+// provider has none and the next provider is asked.
+type Provider interface {
+	Secret() (string, error)
+}
+
+type ProviderFunc func() (string, error)
+
+func (f ProviderFunc) Secret() (string, error) {
+	return f()
+}
+
+// source is a provider known by where it takes its secret from.
+type source struct {
+	Provider
+	from string
+}
+
+func (s source) String() string {
+	return s.from
+}
+
+// Source describes where p takes its secret from, such as the password file
+// or command. It is empty for a nil provider and for providers built without
+// File, Command or Chain.
+func Source(p Provider) string {
+	if s, ok := p.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
+
+// firstLine returns the secret on the first line of b.
+func firstLine(b []byte) string {
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	return strings.TrimSuffix(string(line), "\r")
+}
+
+// Static provides a fixed secret.
+func Static(secret string) Provider {
+	return ProviderFunc(func() (string, error) {
+		return secret, nil
+	})
+}
+
+// File provides the first line of a file.
+func File(path string) Provider {
+	return source{ProviderFunc(func() (string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read password file: %w", err)
+		}
+		return firstLine(b), nil
+	}), "file " + path}
+}
+
+// Command provides the first line printed by a local command, such as
+// "pass show deploy". The command runs through the shell.
+func Command(command string) Provider {
+	return source{ProviderFunc(func() (string, error) {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			return "", fmt.Errorf("password command %q failed: %w", command, err)
+		}
+		return firstLine(out), nil
+	}), "command " + command}
+}
+
+// Env provides the value of an environment variable.
+func Env(name string) Provider {
+	return ProviderFunc(func() (string, error) {
+		return os.Getenv(name), nil
+	})
+}
+
+var stdin struct {
+	once   sync.Once
+	secret string
+	err    error
+}
+
+// Stdin provides the first line piped to the program. It provides nothing
+// when stdin is a terminal. The line is read once and shared by every
+// connection.
+func Stdin() Provider {
+	return ProviderFunc(func() (string, error) {
+		if term.IsTerminal() {
+			return "", nil
+		}
+		stdin.once.Do(func() {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				stdin.err = fmt.Errorf("unable to read password from stdin: %w", err)
+			}
+			stdin.secret = firstLine([]byte(line))
+		})
+		return stdin.secret, stdin.err
+	})
+}
+
+// Prompt asks for the secret on the terminal with the echo disabled. It
+// provides nothing when stdin is not a terminal.
+func Prompt(prompt string) Provider {
+	return ProviderFunc(func() (string, error) {
+		if !term.IsTerminal() {
+			return "", nil
+		}
+		return term.ReadSecret(prompt)
+	})
+}
+
+// Chain asks the providers in order and returns the first secret.
+func Chain(providers ...Provider) Provider {
+	var from []string
+	for _, p := range providers {
+		if s := Source(p); s != "" {
+			from = append(from, s)
+		}
+	}
+	return source{ProviderFunc(func() (string, error) {
+		for _, p := range providers {
+			if p == nil {
+				continue
+			}
+			secret, err := p.Secret()
+			if err != nil || secret != "" {
+				return secret, err
+			}
+		}
+		return "", nil
+	}), strings.Join(from, ", ")}
+}
+
+// Once asks p the first time only and then returns the same result, so a
+// password command runs at most once.
+func Once(p Provider) Provider {
+	var once sync.Once
+	var secret string
+	var err error
+	return ProviderFunc(func() (string, error) {
+		once.Do(func() {
+			secret, err = p.Secret()
+		})
+		return secret, err
+	})
+}
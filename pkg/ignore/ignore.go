@@ -0,0 +1,117 @@
+// Package ignore matches paths with patterns written as the lines of a
+// .gitignore file.
+package ignore
+
+import (
+	"path"
+	"strings"
+)
+
+type rule struct {
+	segments []string // "**" matches any number of path segments
+	negate   bool
+	dirOnly  bool
+}
+
+// Matcher holds patterns, a later pattern taking precedence over the ones
+// before it.
+type Matcher struct {
+	rules []rule
+}
+
+// New returns a Matcher of the patterns.
+func New(patterns ...string) *Matcher {
+	m := &Matcher{}
+	m.Add(patterns...)
+	return m
+}
+
+// Add appends patterns. Blank lines and comments are ignored.
+func (m *Matcher) Add(patterns ...string) {
+	for _, p := range patterns {
+		if r, ok := parseRule(p); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+}
+
+func parseRule(line string) (rule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return rule{}, false
+	}
+	var r rule
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	// a pattern with a slash other than a trailing one is relative to the
+	// root, one without matches a name at any depth
+	anchored := strings.Contains(line, "/")
+	r.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	if !anchored {
+		r.segments = append([]string{"**"}, r.segments...)
+	}
+	return r, true
+}
+
+// Match reports whether the slash separated path name, relative to the root
+// of the patterns, is ignored. isDir tells whether name is a directory.
+// The parents of name are not matched, as a walk does not descend into an
+// ignored directory: as with git, a negated pattern cannot bring back a path
+// below one. A nil Matcher ignores nothing.
+func (m *Matcher) Match(name string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if match(r.segments, parts) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				// a trailing "/**" matches what is inside, not the directory
+				return len(name) > 0
+			}
+			for i := range len(name) {
+				if match(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,69 @@
+package ignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		isDir    bool
+		want     bool
+	}{
+		// unanchored patterns match at any depth
+		{[]string{"*.log"}, "app.log", false, true},
+		{[]string{"*.log"}, "var/log/app.log", false, true},
+		{[]string{"*.log"}, "app.log.gz", false, false},
+		{[]string{"cache"}, "a/b/cache", true, true},
+
+		// a slash anchors the pattern to the root
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "src/build", true, false},
+		{[]string{"docs/*.md"}, "docs/index.md", false, true},
+		{[]string{"docs/*.md"}, "src/docs/index.md", false, false},
+		{[]string{"docs/*.md"}, "docs/api/index.md", false, false},
+
+		// ** matches any number of directories
+		{[]string{"**/testdata"}, "testdata", true, true},
+		{[]string{"**/testdata"}, "pkg/ssh/testdata", true, true},
+		{[]string{"src/**/*.go"}, "src/main.go", false, true},
+		{[]string{"src/**/*.go"}, "src/pkg/ssh/ssh.go", false, true},
+		{[]string{"src/**/*.go"}, "lib/src/main.go", false, false},
+		{[]string{"vendor/**"}, "vendor/a/b.go", false, true},
+		{[]string{"vendor/**"}, "vendor", true, false},
+
+		// a trailing slash only matches directories
+		{[]string{"tmp/"}, "tmp", true, true},
+		{[]string{"tmp/"}, "tmp", false, false},
+		{[]string{"tmp/"}, "a/tmp", true, true},
+
+		// a later negated pattern brings back what an earlier one excluded
+		{[]string{"*.env", "!prod.env"}, "prod.env", false, false},
+		{[]string{"*.env", "!prod.env"}, "dev.env", false, true},
+		{[]string{"!prod.env", "*.env"}, "prod.env", false, true},
+		{[]string{`\!important`}, "!important", false, true},
+		{[]string{`\#notes`}, "#notes", false, true},
+
+		// below an excluded directory a negated pattern does not help, the
+		// walk stops at the directory; excluding its content does
+		{[]string{"logs/", "!logs/keep.log"}, "logs", true, true},
+		{[]string{"logs/*", "!logs/keep.log"}, "logs", true, false},
+		{[]string{"logs/*", "!logs/keep.log"}, "logs/keep.log", false, false},
+		{[]string{"logs/*", "!logs/keep.log"}, "logs/app.log", false, true},
+
+		// blank lines, comments and trailing spaces
+		{[]string{"", "# *.log", "*.tmp  "}, "app.log", false, false},
+		{[]string{"", "# *.log", "*.tmp  "}, "a.tmp", false, true},
+	}
+	for _, tt := range tests {
+		if got := New(tt.patterns...).Match(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("%q.Match(%q, %t) = %t, want %t", tt.patterns, tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestMatchNil(t *testing.T) {
+	var m *Matcher
+	if m.Match("app.log", false) {
+		t.Error("nil Matcher ignores app.log")
+	}
+}
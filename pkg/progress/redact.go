@@ -0,0 +1,82 @@
+package progress
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask replaces secrets in the output.
+const Mask = "****"
+
+// SecretEnv lists, separated by commas, environment variables whose values
+// are secret. Variables named like passwords, passphrases, secrets and
+// tokens are secret without being listed.
+const SecretEnv = "MDEPLOY_SECRET_ENV"
+
+// minSecretLen keeps very short values, which would mask unrelated output,
+// from being treated as secrets.
+const minSecretLen = 3
+
+var secretEnvNames = []string{"PASSWORD", "PASSPHRASE", "SECRET", "TOKEN"}
+
+var secrets struct {
+	sync.RWMutex
+	values   []string
+	replacer *strings.Replacer
+}
+
+// AddSecret masks the values in all output written from now on.
+func AddSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	changed := false
+	for _, v := range values {
+		if len(v) < minSecretLen || slices.Contains(secrets.values, v) {
+			continue
+		}
+		secrets.values = append(secrets.values, v)
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	// longer secrets first, so a secret containing another is masked whole
+	slices.SortFunc(secrets.values, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	pairs := make([]string, 0, 2*len(secrets.values))
+	for _, v := range secrets.values {
+		pairs = append(pairs, v, Mask)
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+}
+
+// AddSecretEnv masks the values of the environment variables marked secret.
+func AddSecretEnv() {
+	listed := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv(SecretEnv), ",") {
+		listed[strings.TrimSpace(name)] = true
+	}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		secret := listed[name]
+		for _, s := range secretEnvNames {
+			secret = secret || strings.Contains(strings.ToUpper(name), s)
+		}
+		if secret {
+			AddSecret(value)
+		}
+	}
+}
+
+// Redact returns s with every secret replaced by Mask.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	if secrets.replacer == nil {
+		return s
+	}
+	return secrets.replacer.Replace(s)
+}
@@ -129,7 +129,7 @@ func (t *ttyWriter) SetStatus(e *Event) {
 		}
 		x.event.EventName = e.EventName
 		x.event.Status = e.Status
-		x.event.Message = e.Message
+		x.event.Message = Redact(e.Message)
 	} else {
 		newEntry := &entry{
 			event: *e,
@@ -142,6 +142,7 @@ func (t *ttyWriter) SetStatus(e *Event) {
 			},
 			output: nil,
 		}
+		newEntry.event.Message = Redact(e.Message)
 		t.eventIds = append(t.eventIds, e.Id)
 		t.events[e.Id] = newEntry
 	}
@@ -372,7 +373,7 @@ func (e *eventOutputWriter) Write(b []byte) (n int, err error) {
 	n = len(b)
 	e.Lock()
 	defer e.Unlock()
-	e.output = append(e.output, string(b))
+	e.output = append(e.output, Redact(string(b)))
 	return
 }
 
@@ -15,7 +15,7 @@ func (t *ttyPlainWritter) StartEvent() {}
 func (t *ttyPlainWritter) StopEvent() {}
 
 func (t *ttyPlainWritter) SetStatus(e *Event) {
-	fmt.Println(e.EventName, ":", e.Message)
+	fmt.Println(e.EventName, ":", Redact(e.Message))
 }
 
 func (t *ttyPlainWritter) SetEventOutput(e *Event, eventOutput EventOutputWriter) {
@@ -39,7 +39,7 @@ func (t *ttyPlainWritter) SetEventOutput(e *Event, eventOutput EventOutputWriter
 				}
 			}
 		}
-	}(e.EventName, e.Message, eventOutput)
+	}(e.EventName, Redact(e.Message), eventOutput)
 }
 
 func (t *ttyPlainWritter) UnSetEventOutput(e *Event) {
@@ -69,9 +69,9 @@ func (p *ttyOutputPlainWriter) Write(b []byte) (n int, err error) {
 	if p.isEventOutput {
 		p.Lock()
 		defer p.Unlock()
-		p.output = append(p.output, string(b))
+		p.output = append(p.output, Redact(string(b)))
 	} else {
-		fmt.Println(string(b))
+		fmt.Println(Redact(string(b)))
 	}
 	return
 }
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package ssh
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time is
+// not read from the platform stat.
+func accessTime(fi os.FileInfo) time.Time {
+	return fi.ModTime()
+}
@@ -0,0 +1,254 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/san-gg/mdeploy/pkg/credential"
+	"github.com/san-gg/mdeploy/pkg/progress"
+	"github.com/san-gg/mdeploy/pkg/term"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
+
+func loadSigner(keyFile, passphrase string, interactive bool) (ssh.Signer, error) {
+	keyFile = expandHome(keyFile)
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key %s: %w", keyFile, err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == "" && interactive {
+			passphrase, err = term.ReadSecret(fmt.Sprintf("Enter passphrase for key '%s': ", keyFile))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if passphrase == "" {
+			return nil, fmt.Errorf("private key %s is encrypted and no passphrase was given", keyFile)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key %s: %w", keyFile, err)
+	}
+	return signer, nil
+}
+
+// loadCertSigner returns a signer presenting the OpenSSH certificate stored
+// next to keyFile as <key>-cert.pub, or nil when there is none.
+func loadCertSigner(keyFile string, signer ssh.Signer) (ssh.Signer, error) {
+	certFile := expandHome(keyFile) + "-cert.pub"
+	data, err := os.ReadFile(certFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read certificate %s: %w", certFile, err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate %s: %w", certFile, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", certFile)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", certFile, err)
+	}
+	return certSigner, nil
+}
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK. A missing
+// or unreachable agent is not an error, the agent is simply skipped.
+func dialAgent() net.Conn {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+func forwardAgent(client *ssh.Client) error {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return fmt.Errorf("agent forwarding requested but SSH_AUTH_SOCK is not set")
+	}
+	if err := agent.ForwardToRemote(client, sock); err != nil {
+		return fmt.Errorf("unable to forward ssh-agent: %w", err)
+	}
+	return nil
+}
+
+// passwordProvider asks for a password in order: the password given, the
+// configured provider, MDEPLOY_PASSWORD, piped stdin and, for interactive
+// use, the terminal. It is asked once per connection.
+func passwordProvider(opt Options) credential.Provider {
+	providers := []credential.Provider{
+		credential.Static(opt.Password),
+		opt.PasswordProvider,
+		credential.Env(credential.PasswordEnv),
+		credential.Stdin(),
+	}
+	if opt.Interactive {
+		providers = append(providers, credential.Prompt(fmt.Sprintf("%s@%s's password: ", opt.User, opt.Server)))
+	}
+	chain := credential.Chain(providers...)
+	return credential.Once(credential.ProviderFunc(func() (string, error) {
+		secret, err := chain.Secret()
+		progress.AddSecret(secret)
+		return secret, err
+	}))
+}
+
+// hasPassword reports whether a password may be available without asking
+// the providers, which may run commands or read stdin.
+func hasPassword(opt Options) bool {
+	return opt.Password != "" || opt.PasswordProvider != nil || opt.Interactive ||
+		os.Getenv(credential.PasswordEnv) != "" || !term.IsTerminal()
+}
+
+func passwordAuth(opt Options, password credential.Provider) []ssh.AuthMethod {
+	if !hasPassword(opt) {
+		return nil
+	}
+	return []ssh.AuthMethod{ssh.PasswordCallback(func() (string, error) {
+		secret, err := password.Secret()
+		if err == nil && secret == "" {
+			err = fmt.Errorf("no password for %s@%s", opt.User, opt.Server)
+		}
+		return secret, err
+	})}
+}
+
+func promptKey(prompt string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(prompt), ":"))
+}
+
+// keyboardInteractiveAuth answers the server prompts from opt.Answers, keyed
+// by prompt text, then with the password for a password prompt, and asks on
+// the terminal for the rest.
+func keyboardInteractiveAuth(opt Options, password credential.Provider) []ssh.AuthMethod {
+	if !hasPassword(opt) && len(opt.Answers) == 0 {
+		return nil
+	}
+	answers := map[string]string{}
+	for prompt, answer := range opt.Answers {
+		answers[promptKey(prompt)] = answer
+	}
+	return []ssh.AuthMethod{ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		replies := make([]string, len(questions))
+		shown := false
+		for i, q := range questions {
+			if answer, ok := answers[promptKey(q)]; ok {
+				replies[i] = answer
+				continue
+			}
+			if strings.Contains(promptKey(q), "password") {
+				secret, err := password.Secret()
+				if err != nil {
+					return nil, err
+				}
+				if secret != "" {
+					replies[i] = secret
+					continue
+				}
+			}
+			if !opt.Interactive {
+				return nil, fmt.Errorf("no answer for prompt %q of %s@%s", strings.TrimSpace(q), opt.User, opt.Server)
+			}
+			if !shown {
+				for _, line := range []string{name, instruction} {
+					if line != "" {
+						fmt.Println(line)
+					}
+				}
+				shown = true
+			}
+			answer, err := term.ReadLine(q, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			replies[i] = answer
+		}
+		return replies, nil
+	})}
+}
+
+// addSecrets masks the password, passphrase and keyboard-interactive answers
+// of opt and of its jump hosts, whether or not they are used to log in.
+func addSecrets(opt Options) {
+	progress.AddSecret(opt.Password, opt.Passphrase)
+	for _, answer := range opt.Answers {
+		progress.AddSecret(answer)
+	}
+	for _, hop := range opt.Jump {
+		addSecrets(hop)
+	}
+}
+
+// authMethods returns the methods in the order they are offered to the
+// server: certificate of the key file, key file, ssh-agent keys, and password
+// or keyboard-interactive only once the keys have been refused. The client
+// tries each method name once, so the key file and the agent keys are offered
+// through a single publickey method.
+func authMethods(opt Options, agentConn net.Conn) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+	addSecrets(opt)
+	if opt.KeyFile != "" {
+		signer, err := loadSigner(opt.KeyFile, opt.Passphrase, opt.Interactive)
+		if err != nil {
+			return nil, err
+		}
+		certSigner, err := loadCertSigner(opt.KeyFile, signer)
+		if err != nil {
+			return nil, err
+		}
+		if certSigner != nil {
+			signers = append(signers, certSigner)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 || agentConn != nil {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agentConn == nil {
+				return signers, nil
+			}
+			agentSigners, err := agent.NewClient(agentConn).Signers()
+			if err != nil {
+				return signers, nil
+			}
+			return append(signers, agentSigners...), nil
+		}))
+	}
+	password := passwordProvider(opt)
+	methods = append(methods, passwordAuth(opt, password)...)
+	methods = append(methods, keyboardInteractiveAuth(opt, password)...)
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no authentication method for %s@%s", opt.User, opt.Server)
+	}
+	return methods, nil
+}
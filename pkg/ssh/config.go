@@ -0,0 +1,315 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultConfigFile = "~/.ssh/config"
+
+// HostConfig holds the OpenSSH client options resolved for a single host.
+type HostConfig struct {
+	HostName              string
+	Port                  int
+	User                  string
+	IdentityFiles         []string
+	ProxyJump             string
+	StrictHostKeyChecking string
+	UserKnownHostsFile    string
+	HashKnownHosts        bool
+	ConnectTimeout        time.Duration
+	ServerAliveInterval   time.Duration // negative when disabled
+}
+
+type configBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// Config is a parsed OpenSSH client configuration file. Only Host blocks are
+// evaluated, Match blocks are skipped.
+type Config struct {
+	blocks []configBlock
+}
+
+// LoadConfig parses the ssh client config at file, following Include
+// directives. A missing default config is not an error.
+func LoadConfig(file string) (*Config, error) {
+	c := &Config{}
+	if file == "" {
+		file = DefaultConfigFile
+	}
+	if err := c.parseFile(expandHome(file), 0, []string{"*"}); err != nil {
+		if os.IsNotExist(err) && file == DefaultConfigFile {
+			return c, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) parseFile(file string, depth int, patterns []string) error {
+	if depth > 16 {
+		return fmt.Errorf("ssh config: too many nested includes in %s", file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// lines before the first Host keyword belong to the enclosing block,
+	// which is "*" at the top level
+	c.blocks = append(c.blocks, configBlock{patterns: patterns})
+	current := len(c.blocks) - 1
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		key, args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("ssh config %s:%d: %w", file, lineno, err)
+		}
+		if key == "" {
+			continue
+		}
+		switch key {
+		case "host":
+			c.blocks = append(c.blocks, configBlock{patterns: args})
+			current = len(c.blocks) - 1
+		case "match":
+			c.blocks = append(c.blocks, configBlock{})
+			current = len(c.blocks) - 1
+		case "include":
+			enclosing := c.blocks[current].patterns
+			for _, inc := range args {
+				inc = expandHome(inc)
+				if !filepath.IsAbs(inc) {
+					inc = filepath.Join(expandHome("~/.ssh"), inc)
+				}
+				matches, _ := filepath.Glob(inc)
+				for _, m := range matches {
+					if err := c.parseFile(m, depth+1, enclosing); err != nil {
+						return err
+					}
+				}
+			}
+			c.blocks = append(c.blocks, configBlock{patterns: enclosing})
+			current = len(c.blocks) - 1
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("ssh config %s:%d: missing argument for %s", file, lineno, key)
+			}
+			c.blocks[current].options = append(c.blocks[current].options, [2]string{key, strings.Join(args, " ")})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitConfigLine splits "Key value", "Key=value" and quoted arguments.
+func splitConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:i])
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	rest = strings.TrimLeft(rest, " \t")
+
+	var args []string
+	for rest != "" {
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			args = append(args, rest[1:end+1])
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			args = append(args, rest[:end])
+			rest = rest[end:]
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return key, args, nil
+}
+
+func (b *configBlock) matches(host string) bool {
+	matched := false
+	for _, p := range b.patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		for _, alt := range strings.Split(p, ",") {
+			if ok, _ := path.Match(strings.ToLower(alt), strings.ToLower(host)); ok {
+				if negate {
+					return false
+				}
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// Lookup resolves the options for host the way ssh(1) does: the first value
+// obtained for an option wins, IdentityFile accumulates.
+func (c *Config) Lookup(host string) HostConfig {
+	hc := HostConfig{}
+	seen := map[string]bool{}
+	for i := range c.blocks {
+		if !c.blocks[i].matches(host) {
+			continue
+		}
+		for _, o := range c.blocks[i].options {
+			key, val := o[0], o[1]
+			if key == "identityfile" {
+				hc.IdentityFiles = append(hc.IdentityFiles, val)
+				continue
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			switch key {
+			case "hostname":
+				hc.HostName = strings.ReplaceAll(val, "%h", host)
+			case "port":
+				hc.Port, _ = strconv.Atoi(val)
+			case "user":
+				hc.User = val
+			case "proxyjump":
+				hc.ProxyJump = val
+			case "stricthostkeychecking":
+				hc.StrictHostKeyChecking = strings.ToLower(val)
+			case "userknownhostsfile":
+				hc.UserKnownHostsFile = strings.Fields(val)[0]
+			case "hashknownhosts":
+				hc.HashKnownHosts = strings.EqualFold(val, "yes")
+			case "connecttimeout":
+				if n, err := strconv.Atoi(val); err == nil && n > 0 {
+					hc.ConnectTimeout = time.Duration(n) * time.Second
+				}
+			case "serveraliveinterval":
+				if n, err := strconv.Atoi(val); err == nil {
+					hc.ServerAliveInterval = time.Duration(n) * time.Second
+					if n == 0 {
+						hc.ServerAliveInterval = -1
+					}
+				}
+			}
+		}
+	}
+	return hc
+}
+
+func expandTokens(s, host, remoteUser string) string {
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+	home, _ := os.UserHomeDir()
+	s = expandHome(s)
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", host,
+		"%r", remoteUser,
+		"%u", localUser,
+	).Replace(s)
+}
+
+// Apply fills the options left unset in opt, and in each of its jump hosts,
+// from the config entry matching opt.Server, which may be a Host alias. A
+// ProxyJump entry is used when no jump hosts were given.
+func (c *Config) Apply(opt *Options) {
+	hc := c.applyHost(opt)
+	if len(opt.Jump) == 0 && hc.ProxyJump != "" && !strings.EqualFold(hc.ProxyJump, "none") {
+		for _, j := range strings.Split(hc.ProxyJump, ",") {
+			user, host, port, err := ParseDestination(strings.TrimSpace(j))
+			if err != nil {
+				continue
+			}
+			opt.Jump = append(opt.Jump, Options{
+				Server:         host,
+				Port:           port,
+				User:           user,
+				Interactive:    opt.Interactive,
+				HostKeyPolicy:  opt.HostKeyPolicy,
+				KnownHostsFile: opt.KnownHostsFile,
+				ConnectTimeout: opt.ConnectTimeout,
+				KeepAlive:      opt.KeepAlive,
+				Proxy:          opt.Proxy,
+			})
+		}
+	}
+	for i := range opt.Jump {
+		c.applyHost(&opt.Jump[i])
+	}
+}
+
+func (c *Config) applyHost(opt *Options) HostConfig {
+	hc := c.Lookup(opt.Server)
+	if hc.HostName != "" {
+		opt.Server = hc.HostName
+	}
+	if opt.Port == 0 {
+		opt.Port = hc.Port
+	}
+	if opt.Port == 0 {
+		opt.Port = 22
+	}
+	if opt.User == "" {
+		opt.User = hc.User
+	}
+	if opt.User == "" {
+		if u, err := user.Current(); err == nil {
+			opt.User = u.Username
+		}
+	}
+	if opt.KeyFile == "" {
+		for _, id := range hc.IdentityFiles {
+			id = expandTokens(id, opt.Server, opt.User)
+			if _, err := os.Stat(id); err == nil {
+				opt.KeyFile = id
+				break
+			}
+		}
+	}
+	if opt.HostKeyPolicy == "" {
+		switch hc.StrictHostKeyChecking {
+		case "yes", "ask":
+			opt.HostKeyPolicy = HostKeyStrict
+		case "accept-new":
+			opt.HostKeyPolicy = HostKeyAcceptNew
+		case "no", "off":
+			opt.HostKeyPolicy = HostKeyOff
+		}
+	}
+	if opt.KnownHostsFile == "" && hc.UserKnownHostsFile != "" {
+		opt.KnownHostsFile = expandTokens(hc.UserKnownHostsFile, opt.Server, opt.User)
+	}
+	opt.HashKnownHosts = opt.HashKnownHosts || hc.HashKnownHosts
+	if opt.ConnectTimeout == 0 {
+		opt.ConnectTimeout = hc.ConnectTimeout
+	}
+	if opt.KeepAlive == 0 {
+		opt.KeepAlive = hc.ServerAliveInterval
+	}
+	return hc
+}
@@ -0,0 +1,140 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ParsePublicKey parses a public key file, which must hold a single
+// authorized_keys line. It returns the key and the line rewritten in the
+// form ssh-keygen writes it.
+func ParsePublicKey(data []byte) (ssh.PublicKey, []byte, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.ContainsAny(data, "\r\n") {
+		return nil, nil, errors.New("more than one line, the file must hold a single key")
+	}
+	key, comment, options, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	line := bytes.TrimSpace(ssh.MarshalAuthorizedKey(key))
+	if len(options) > 0 {
+		line = append([]byte(strings.Join(options, ",")+" "), line...)
+	}
+	if comment != "" {
+		line = append(line, " "+comment...)
+	}
+	return key, line, nil
+}
+
+// InstallPublicKey appends the authorized_keys line of a public key to
+// ~/.ssh/authorized_keys, creating ~/.ssh with mode 0700 and the file with
+// mode 0600. It reports false when the key is already authorized.
+func (s *sshSession) InstallPublicKey(data []byte) (bool, error) {
+	key, line, err := ParsePublicKey(data)
+	if err != nil {
+		return false, fmt.Errorf("invalid public key: %w", err)
+	}
+	home, err := s.sftp.RealPath(".")
+	if err != nil {
+		return false, err
+	}
+	dir := path.Join(home, ".ssh")
+	if _, err := s.sftp.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := s.sftp.MkdirMode(dir, 0700); err != nil {
+			return false, fmt.Errorf("unable to create %s: %w", dir, err)
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	file := path.Join(dir, "authorized_keys")
+	f, err := s.sftp.OpenFile(file, sshFxfRead|sshFxfWrite|sshFxfCreat, 0600)
+	if err != nil {
+		return false, fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.close()
+	var existing bytes.Buffer
+	if _, err := f.writeTo(&existing, 0, false); err != nil {
+		return false, fmt.Errorf("unable to read %s: %w", file, err)
+	}
+	if authorized(existing.Bytes(), key) {
+		return false, nil
+	}
+
+	var add bytes.Buffer
+	if existing.Len() > 0 && !bytes.HasSuffix(existing.Bytes(), []byte("\n")) {
+		add.WriteString("\n")
+	}
+	add.Write(line)
+	add.WriteString("\n")
+	// the read left the offset at the end of the file
+	if _, err := f.readFrom(&add, int64(add.Len()), false); err != nil {
+		return false, fmt.Errorf("unable to write %s: %w", file, err)
+	}
+	return true, nil
+}
+
+// authorized reports whether an authorized_keys file lists key, whatever
+// its options and comment.
+func authorized(authorizedKeys []byte, key ssh.PublicKey) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(authorizedKeys))
+	for scanner.Scan() {
+		k, _, _, _, err := ssh.ParseAuthorizedKey(scanner.Bytes())
+		if err == nil && bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPublicKey logs in with nothing but the private key of pub, read from
+// keyFile or, when there is no such file, held by the ssh-agent. Jump hosts
+// authenticate as usual.
+func VerifyPublicKey(opt Options, pub ssh.PublicKey, keyFile string) error {
+	agentConn := dialAgent()
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+	var signer ssh.Signer
+	if _, err := os.Stat(expandHome(keyFile)); err == nil {
+		if signer, err = loadSigner(keyFile, opt.Passphrase, opt.Interactive); err != nil {
+			return err
+		}
+		if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			return fmt.Errorf("private key %s does not match the public key", keyFile)
+		}
+	} else if agentConn != nil {
+		signers, _ := agent.NewClient(agentConn).Signers()
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), pub.Marshal()) {
+				signer = s
+			}
+		}
+	}
+	if signer == nil {
+		return fmt.Errorf("private key %s not found and not held by the ssh-agent", keyFile)
+	}
+
+	hop := 0
+	conn, err := connect(opt, func(o Options) ([]ssh.AuthMethod, error) {
+		hop++
+		if hop <= len(opt.Jump) {
+			return authMethods(o, agentConn)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
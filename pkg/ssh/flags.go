@@ -0,0 +1,73 @@
+package ssh
+
+import (
+	"github.com/san-gg/mdeploy/pkg/credential"
+	"github.com/spf13/cobra"
+)
+
+// PasswordProvider returns the provider reading a password file or running a
+// password command, the file is read first. It returns nil when both are
+// empty.
+func PasswordProvider(file, command string) credential.Provider {
+	var providers []credential.Provider
+	if file != "" {
+		providers = append(providers, credential.File(expandHome(file)))
+	}
+	if command != "" {
+		providers = append(providers, credential.Command(command))
+	}
+	if len(providers) == 0 {
+		return nil
+	}
+	return credential.Chain(providers...)
+}
+
+// NewOptions returns Options holding the host key and connection settings
+// given through the persistent flags of the root command. Settings without
+// a flag are left empty, so the OpenSSH client config can still provide them.
+func NewOptions(cmd *cobra.Command) (Options, error) {
+	var opt Options
+	flags := cmd.Flags()
+	policy, err := flags.GetString("host-key-policy")
+	if err != nil {
+		panic(err)
+	}
+	trust, err := flags.GetBool("trust")
+	if err != nil {
+		panic(err)
+	}
+	knownHosts, err := flags.GetString("known-hosts")
+	if err != nil {
+		panic(err)
+	}
+	if opt.ConnectTimeout, err = flags.GetDuration("connect-timeout"); err != nil {
+		panic(err)
+	}
+	if opt.KeepAlive, err = flags.GetDuration("keepalive"); err != nil {
+		panic(err)
+	}
+	if opt.Proxy, err = flags.GetString("proxy"); err != nil {
+		panic(err)
+	}
+	passwordFile, err := flags.GetString("password-file")
+	if err != nil {
+		panic(err)
+	}
+	passwordCommand, err := flags.GetString("password-command")
+	if err != nil {
+		panic(err)
+	}
+	opt.PasswordProvider = PasswordProvider(passwordFile, passwordCommand)
+	if flags.Changed("keepalive") && opt.KeepAlive == 0 {
+		opt.KeepAlive = -1
+	}
+	if flags.Changed("host-key-policy") {
+		if opt.HostKeyPolicy, err = ParseHostKeyPolicy(policy); err != nil {
+			return opt, err
+		}
+	} else if trust {
+		opt.HostKeyPolicy = HostKeyAcceptNew
+	}
+	opt.KnownHostsFile = knownHosts
+	return opt, nil
+}
@@ -0,0 +1,457 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const DefaultKnownHostsFile = "~/.ssh/known_hosts"
+
+type HostKeyPolicy string
+
+const (
+	// HostKeyStrict only accepts hosts already present in known_hosts.
+	HostKeyStrict HostKeyPolicy = "strict"
+	// HostKeyAcceptNew records unknown hosts but refuses changed keys.
+	HostKeyAcceptNew HostKeyPolicy = "accept-new"
+	// HostKeyOff disables host key checking.
+	HostKeyOff HostKeyPolicy = "off"
+)
+
+func ParseHostKeyPolicy(policy string) (HostKeyPolicy, error) {
+	switch HostKeyPolicy(policy) {
+	case HostKeyStrict, HostKeyAcceptNew, HostKeyOff:
+		return HostKeyPolicy(policy), nil
+	case "":
+		return HostKeyStrict, nil
+	}
+	return "", fmt.Errorf("invalid host key policy %q: must be strict, accept-new or off", policy)
+}
+
+type hostKeyChecker struct {
+	policy HostKeyPolicy
+	file   string
+	hash   bool
+}
+
+func newHostKeyChecker(opt Options) hostKeyChecker {
+	h := hostKeyChecker{
+		policy: opt.HostKeyPolicy,
+		file:   opt.KnownHostsFile,
+		hash:   opt.HashKnownHosts,
+	}
+	if h.policy == "" {
+		h.policy = HostKeyStrict
+	}
+	if h.file == "" {
+		h.file = DefaultKnownHostsFile
+	}
+	h.file = expandHome(h.file)
+	return h
+}
+
+// certCallback checks a host certificate against the cert-authority and
+// revoked lines of the known hosts file.
+func (h hostKeyChecker) certCallback() (ssh.HostKeyCallback, error) {
+	_, hosts, err := readKnownHosts(h.file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known hosts file %s: %w", h.file, err)
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			address = knownhosts.Normalize(address)
+			return slices.ContainsFunc(hosts, func(k KnownHost) bool {
+				return k.Marker == "cert-authority" && k.matches(address) && bytes.Equal(k.Key.Marshal(), auth.Marshal())
+			})
+		},
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return slices.ContainsFunc(hosts, func(k KnownHost) bool {
+				return k.Marker == "revoked" && bytes.Equal(k.Key.Marshal(), cert.Marshal())
+			})
+		},
+	}
+	return checker.CheckHostKey, nil
+}
+
+var (
+	certHostKeyAlgos = []string{
+		ssh.CertAlgoED25519v01,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01,
+	}
+	plainHostKeyAlgos = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+	}
+)
+
+// hostKeyAlgorithms lists the algorithms the server may use for address, so
+// that it offers a key that can be checked instead of another type that
+// would look like a changed key. Certificates are only asked for when a
+// cert-authority line covers the host, followed by the keys already known
+// or, for an unknown host, any plain key that can be recorded.
+func (h hostKeyChecker) hostKeyAlgorithms(address string) []string {
+	if h.policy == HostKeyOff {
+		return nil
+	}
+	_, hosts, err := readKnownHosts(h.file)
+	if err != nil {
+		return nil
+	}
+	address = knownhosts.Normalize(address)
+	var certs, keys []string
+	for _, k := range hosts {
+		if !k.matches(address) {
+			continue
+		}
+		switch k.Marker {
+		case "cert-authority":
+			certs = certHostKeyAlgos
+		case "":
+			if k.Key.Type() == ssh.KeyAlgoRSA {
+				keys = append(keys, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+			}
+			keys = append(keys, k.Key.Type())
+		}
+	}
+	if len(keys) == 0 {
+		keys = plainHostKeyAlgos
+	}
+	return append(append([]string{}, certs...), keys...)
+}
+
+// serverHostKey leaves certificates to the knownhosts CertChecker, which
+// trusts them through cert-authority lines. Plain keys are compared with
+// the matching entries here, as knownhosts would also count the CA keys as
+// keys of the host.
+func (h hostKeyChecker) serverHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if h.policy == HostKeyOff {
+		return nil
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		callback, err := h.certCallback()
+		if err != nil {
+			return err
+		}
+		return callback(hostname, remote, key)
+	}
+	// the file is read on every check, so keys recorded for an earlier jump
+	// host are seen by the next one
+	_, hosts, err := readKnownHosts(h.file)
+	if err != nil {
+		return fmt.Errorf("unable to read known hosts file %s: %w", h.file, err)
+	}
+	address := knownhosts.Normalize(hostname)
+	var want []knownhosts.KnownKey
+	for _, k := range hosts {
+		if k.Marker == "revoked" && bytes.Equal(k.Key.Marshal(), key.Marshal()) {
+			return fmt.Errorf("host key for %s is revoked (%s:%d)", hostname, h.file, k.Line)
+		}
+		if k.Marker != "" || !k.matches(address) {
+			continue
+		}
+		if bytes.Equal(k.Key.Marshal(), key.Marshal()) {
+			return nil
+		}
+		want = append(want, knownhosts.KnownKey{Key: k.Key, Filename: h.file, Line: k.Line})
+	}
+	if len(want) > 0 {
+		return hostKeyMismatchError(hostname, key, want)
+	}
+	if h.policy != HostKeyAcceptNew {
+		return fmt.Errorf("host key for %s is not known (%s %s), use --host-key-policy=accept-new to trust it",
+			hostname, key.Type(), ssh.FingerprintSHA256(key))
+	}
+	return h.add(hostname, key)
+}
+
+func (h hostKeyChecker) add(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(h.file), 0700); err != nil {
+		return fmt.Errorf("failed to create known hosts directory: %w", err)
+	}
+	file, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known hosts file")
+	}
+	defer file.Close()
+
+	host := knownhosts.Normalize(hostname)
+	if h.hash {
+		host = knownhosts.HashHostname(host)
+	}
+	line := knownhosts.Line([]string{host}, key)
+	_, err = file.WriteString(line + "\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to known hosts file")
+	}
+	return nil
+}
+
+func hostKeyMismatchError(hostname string, key ssh.PublicKey, want []knownhosts.KnownKey) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "host key for %s has changed, possible man-in-the-middle attack\n", hostname)
+	fmt.Fprintf(&b, "  offered: %s %s\n", key.Type(), ssh.FingerprintSHA256(key))
+	for _, w := range want {
+		fmt.Fprintf(&b, "  known:   %s %s (%s:%d)\n", w.Key.Type(), ssh.FingerprintSHA256(w.Key), w.Filename, w.Line)
+	}
+	return errors.New(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// KnownHost is a single entry of a known hosts file.
+type KnownHost struct {
+	Line    int
+	Marker  string // "cert-authority", "revoked" or empty
+	Hosts   []string
+	Key     ssh.PublicKey
+	Comment string
+}
+
+func (k KnownHost) Fingerprint() string {
+	return ssh.FingerprintSHA256(k.Key)
+}
+
+// matches reports whether one of the entry's host patterns, plain or
+// hashed, names host. host must be normalized.
+func (k KnownHost) matches(host string) bool {
+	matched := false
+	for _, pattern := range k.Hosts {
+		if strings.HasPrefix(pattern, "|1|") {
+			parts := strings.Split(pattern, "|")
+			if len(parts) != 4 {
+				continue
+			}
+			salt, err1 := base64.StdEncoding.DecodeString(parts[2])
+			hash, err2 := base64.StdEncoding.DecodeString(parts[3])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			mac := hmac.New(sha1.New, salt)
+			mac.Write([]byte(host))
+			if hmac.Equal(mac.Sum(nil), hash) {
+				matched = true
+			}
+			continue
+		}
+		negate := strings.HasPrefix(pattern, "!")
+		// only * and ? are wildcards, brackets enclose a host with a port
+		pattern = strings.NewReplacer("[", "\\[", "]", "\\]").Replace(strings.TrimPrefix(pattern, "!"))
+		if ok, _ := path.Match(pattern, host); ok {
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+func knownHostsFile(file string) string {
+	if file == "" {
+		file = DefaultKnownHostsFile
+	}
+	return expandHome(file)
+}
+
+// readKnownHosts returns the lines of the known hosts file and the entries
+// parsed from them.
+func readKnownHosts(file string) ([]string, []KnownHost, error) {
+	data, err := os.ReadFile(knownHostsFile(file))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var hosts []KnownHost
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		marker, patterns, key, comment, _, err := ssh.ParseKnownHosts([]byte(trimmed))
+		if err != nil {
+			// skipped as ssh does, a key type unknown here or a broken line
+			// does not hide the other entries and is written back as it is
+			continue
+		}
+		hosts = append(hosts, KnownHost{
+			Line:    i + 1,
+			Marker:  marker,
+			Hosts:   patterns,
+			Key:     key,
+			Comment: comment,
+		})
+	}
+	return lines, hosts, nil
+}
+
+// NormalizeHost turns host, host:port or [host]:port into the form used in
+// known hosts files.
+func NormalizeHost(host string) (string, error) {
+	_, host, port, err := ParseDestination(host)
+	if err != nil {
+		return "", err
+	}
+	if port == 0 {
+		port = 22
+	}
+	return knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port))), nil
+}
+
+// ListKnownHosts returns the entries of the known hosts file.
+func ListKnownHosts(file string) ([]KnownHost, error) {
+	_, hosts, err := readKnownHosts(file)
+	return hosts, err
+}
+
+// LookupKnownHost returns the entries of the known hosts file naming host.
+func LookupKnownHost(file, host string) ([]KnownHost, error) {
+	host, err := NormalizeHost(host)
+	if err != nil {
+		return nil, err
+	}
+	_, hosts, err := readKnownHosts(file)
+	if err != nil {
+		return nil, err
+	}
+	var found []KnownHost
+	for _, h := range hosts {
+		if h.matches(host) {
+			found = append(found, h)
+		}
+	}
+	return found, nil
+}
+
+// AddKnownHost records key for host, unless the same key is already known.
+func AddKnownHost(file, host string, key ssh.PublicKey, hash bool) (bool, error) {
+	host, err := NormalizeHost(host)
+	if err != nil {
+		return false, err
+	}
+	known, err := LookupKnownHost(file, host)
+	if err != nil {
+		return false, err
+	}
+	for _, k := range known {
+		if k.Marker == "" && bytes.Equal(k.Key.Marshal(), key.Marshal()) {
+			return false, nil
+		}
+	}
+	h := hostKeyChecker{file: knownHostsFile(file), hash: hash}
+	return true, h.add(host, key)
+}
+
+// RemoveKnownHost deletes every entry naming host and returns how many
+// were removed.
+func RemoveKnownHost(file, host string) (int, error) {
+	normalized, err := NormalizeHost(host)
+	if err != nil {
+		return 0, err
+	}
+	lines, hosts, err := readKnownHosts(file)
+	if err != nil {
+		return 0, err
+	}
+	drop := map[int]bool{}
+	for _, h := range hosts {
+		if h.Marker == "" && h.matches(normalized) {
+			drop[h.Line] = true
+		}
+	}
+	if len(drop) == 0 {
+		return 0, nil
+	}
+	var b strings.Builder
+	for i, line := range lines {
+		if !drop[i+1] {
+			b.WriteString(line + "\n")
+		}
+	}
+	file = knownHostsFile(file)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(drop), nil
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanHostKeys fetches the host keys offered by the server without
+// authenticating, one handshake per key type. The server is reached through
+// its jump hosts, which authenticate as for a session, or its proxy, within
+// the connect timeout.
+func ScanHostKeys(opt Options) ([]ssh.PublicKey, error) {
+	timeout := opt.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	var via *ssh.Client
+	if len(opt.Jump) > 0 {
+		bastion := opt.Jump[len(opt.Jump)-1]
+		bastion.Jump = opt.Jump[:len(opt.Jump)-1]
+		conn, err := dial(bastion)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		via = conn.client
+	}
+	algos := []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512,
+	}
+	var keys []ssh.PublicKey
+	var lastErr error
+	for _, algo := range algos {
+		var key ssh.PublicKey
+		config := &ssh.ClientConfig{
+			User:              opt.User,
+			HostKeyAlgorithms: []string{algo},
+			HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+				key = k
+				return errHostKeyScanned
+			},
+		}
+		conn, err := dialConn(opt, via, timeout)
+		if err != nil {
+			return nil, err
+		}
+		c, _, _, err := handshake(conn, opt, config, timeout)
+		if err == nil {
+			c.Close()
+		}
+		if key != nil {
+			keys = append(keys, key)
+		} else if err != nil {
+			lastErr = err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cannot scan host keys of %s: %w", opt.Server, lastErr)
+	}
+	return keys, nil
+}
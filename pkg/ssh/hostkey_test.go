@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer starts an SSH server offering an ed25519 host key and returns
+// its address and key.
+func sshServer(t *testing.T) (string, ssh.PublicKey) {
+	signer := newSigner(t)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	addr := listen(t, func(conn net.Conn) {
+		ssh.NewServerConn(conn, config)
+	})
+	return addr, signer.PublicKey()
+}
+
+// newSigner returns a new ed25519 key.
+func newSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestKnownHostsUnparseableLine(t *testing.T) {
+	web, db, ca, host := newSigner(t), newSigner(t), newSigner(t), newSigner(t)
+	lines := []string{
+		"# known hosts",
+		"web1 this is not a key",
+		"web1 " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(web.PublicKey()))),
+		"[::1]:2222 ssh-unknown AAAAC3NzaC1lZDI1NTE5",
+		"@cert-authority *.example.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.PublicKey()))),
+		"db1 " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(db.PublicKey()))),
+	}
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := ListKnownHosts(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 3 {
+		t.Errorf("got %d entries, want 3", len(hosts))
+	}
+
+	h := hostKeyChecker{policy: HostKeyStrict, file: file}
+	if err := h.serverHostKey("web1:22", nil, web.PublicKey()); err != nil {
+		t.Errorf("known key: %v", err)
+	}
+	if err := h.serverHostKey("web1:22", nil, db.PublicKey()); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("changed key: got %v", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             host.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"app.example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.serverHostKey("app.example.com:22", nil, cert); err != nil {
+		t.Errorf("certificate: %v", err)
+	}
+
+	if n, err := RemoveKnownHost(file, "db1"); err != nil || n != 1 {
+		t.Fatalf("removed %d, %v", n, err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(lines[:5], "\n") + "\n"; string(b) != want {
+		t.Errorf("file after removal:\n%s\nwant:\n%s", b, want)
+	}
+}
+
+func TestScanHostKeysThroughProxy(t *testing.T) {
+	addr, want := sshServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	reqs := make(chan socks5Request, 8)
+	proxy := socks5Stub(t, addr, "", "", reqs)
+
+	opt := Options{Server: host, Proxy: "socks5://" + proxy, ConnectTimeout: 5 * time.Second}
+	opt.Port, _ = strconv.Atoi(port)
+	keys, err := ScanHostKeys(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0].Marshal(), want.Marshal()) {
+		t.Errorf("got %d keys, want the ed25519 host key", len(keys))
+	}
+	if len(reqs) == 0 {
+		t.Error("scan did not go through the proxy")
+	}
+}
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/san-gg/mdeploy/pkg/credential"
+)
+
+// Manager hands out sessions sharing one SSH connection per user, host,
+// port and way of logging in. Every session opens its own SFTP channel over
+// the connection. The connections stay open for later sessions until the
+// manager is closed.
+type Manager struct {
+	mu    sync.Mutex
+	conns map[string]*managedConn
+}
+
+type managedConn struct {
+	conn  *sshConn
+	err   error
+	ready chan struct{}
+}
+
+func NewManager() *Manager {
+	return &Manager{conns: map[string]*managedConn{}}
+}
+
+// ConnectionKey identifies the user and server opt logs in to.
+func ConnectionKey(opt Options) string {
+	return opt.User + "@" + opt.address()
+}
+
+// poolKey identifies the connection opt is served by. Options share a
+// connection only when they also log in the same way: with the same
+// credentials, password file or command, keyboard-interactive answers, host
+// key checking, jump hosts, proxy and agent forwarding. The options of the
+// first session are used to open it.
+func poolKey(opt Options) string {
+	h := sha256.New()
+	login := func(o Options) {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00", ConnectionKey(o), o.KeyFile, o.Passphrase, o.Password,
+			credential.Source(o.PasswordProvider), o.Proxy)
+		prompts := slices.Sorted(maps.Keys(o.Answers))
+		for _, prompt := range prompts {
+			fmt.Fprintf(h, "%s\x00%s\x00", prompt, o.Answers[prompt])
+		}
+		fmt.Fprintf(h, "%d\x00%s\x00%s\x00", len(prompts), o.HostKeyPolicy, o.KnownHostsFile)
+	}
+	login(opt)
+	fmt.Fprintf(h, "%t\x00", opt.ForwardAgent)
+	for _, j := range opt.Jump {
+		login(j)
+	}
+	return ConnectionKey(opt) + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *Manager) Connect(opt Options) (SshSession, error) {
+	return newSession(m, opt)
+}
+
+// get returns the shared connection for opt, dialing it when there is none
+// yet or when it is the broken connection of a session reconnecting.
+func (m *Manager) get(opt Options, broken *sshConn) (*sshConn, error) {
+	key := poolKey(opt)
+	m.mu.Lock()
+	mc, ok := m.conns[key]
+	if ok && broken != nil {
+		select {
+		case <-mc.ready:
+			if mc.conn == broken {
+				broken.Close()
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		mc = &managedConn{ready: make(chan struct{})}
+		m.conns[key] = mc
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		mc.conn, mc.err = dial(opt)
+		close(mc.ready)
+	}
+	<-mc.ready
+	if mc.err != nil {
+		// a failed connection is dialed again by the next session
+		m.mu.Lock()
+		if m.conns[key] == mc {
+			delete(m.conns, key)
+		}
+		m.mu.Unlock()
+		return nil, mc.err
+	}
+	return mc.conn, nil
+}
+
+// Close closes every connection. Sessions still open on them stop working.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, mc := range m.conns {
+		<-mc.ready
+		if mc.conn != nil {
+			mc.conn.Close()
+		}
+		delete(m.conns, key)
+	}
+}
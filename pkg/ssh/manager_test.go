@@ -0,0 +1,57 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/san-gg/mdeploy/pkg/credential"
+)
+
+func TestPoolKey(t *testing.T) {
+	base := Options{
+		Server:           "web1",
+		User:             "deploy",
+		KeyFile:          "~/.ssh/id_ed25519",
+		PasswordProvider: credential.Chain(credential.File("/run/secrets/web1")),
+		Answers:          map[string]string{"Password: ": "secret", "OTP: ": "000000"},
+		Jump:             []Options{{Server: "bastion", User: "admin"}},
+	}
+	tests := []struct {
+		name   string
+		change func(o *Options)
+		shared bool
+	}{
+		{"same options", func(o *Options) {}, true},
+		{"default port", func(o *Options) { o.Port = 22 }, true},
+		{"sftp concurrency", func(o *Options) { o.SftpConcurrency = true }, true},
+		{"same provider and answers", func(o *Options) {
+			o.PasswordProvider = credential.Chain(credential.File("/run/secrets/web1"))
+			o.Answers = map[string]string{"OTP: ": "000000", "Password: ": "secret"}
+		}, true},
+		{"forward agent", func(o *Options) { o.ForwardAgent = true }, false},
+		{"key file", func(o *Options) { o.KeyFile = "~/.ssh/deploy" }, false},
+		{"password", func(o *Options) { o.Password = "secret" }, false},
+		{"password file", func(o *Options) { o.PasswordProvider = credential.File("/run/secrets/deploy") }, false},
+		{"password command", func(o *Options) { o.PasswordProvider = credential.Command("pass show deploy") }, false},
+		{"no password provider", func(o *Options) { o.PasswordProvider = nil }, false},
+		{"answers", func(o *Options) { o.Answers = map[string]string{"OTP: ": "123456"} }, false},
+		{"host key policy", func(o *Options) { o.HostKeyPolicy = HostKeyOff }, false},
+		{"known hosts file", func(o *Options) { o.KnownHostsFile = "/etc/mdeploy/known_hosts" }, false},
+		{"proxy", func(o *Options) { o.Proxy = "socks5://proxy:1080" }, false},
+		{"no jump", func(o *Options) { o.Jump = nil }, false},
+		{"other jump", func(o *Options) { o.Jump = []Options{{Server: "bastion2", User: "admin"}} }, false},
+		{"jump key file", func(o *Options) { o.Jump = []Options{{Server: "bastion", User: "admin", KeyFile: "~/.ssh/bastion"}} }, false},
+		{"jump password command", func(o *Options) {
+			o.Jump = []Options{{Server: "bastion", User: "admin", PasswordProvider: credential.Command("pass show bastion")}}
+		}, false},
+	}
+	for _, tt := range tests {
+		opt := base
+		tt.change(&opt)
+		if got := poolKey(opt) == poolKey(base); got != tt.shared {
+			t.Errorf("%s: shared %t, want %t", tt.name, got, tt.shared)
+		}
+		if ConnectionKey(opt) != ConnectionKey(base) {
+			t.Errorf("%s: ConnectionKey changed", tt.name)
+		}
+	}
+}
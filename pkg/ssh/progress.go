@@ -75,6 +75,11 @@ func (c *progressCopy) SetSize(size int64) {
 	c.size = size
 }
 
+// SetOffset counts the bytes a resumed transfer skips as transferred.
+func (c *progressCopy) SetOffset(offset int64) {
+	c.bytesRead = offset
+}
+
 type progressBar struct {
 	speed float64 // per sec
 }
@@ -0,0 +1,327 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const banner = "SSH-2.0-stub\r\n"
+
+// listen starts serving every connection accepted on a local port with serve
+// and returns its address.
+func listen(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// target stands in for an SSH server, it sends its banner and echoes what it
+// reads.
+func target(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {
+		io.WriteString(conn, banner)
+		io.Copy(conn, conn)
+	})
+}
+
+// relayTo connects conn to the target, whatever address the proxy was asked
+// for.
+func relayTo(conn io.ReadWriter, targetAddr string) {
+	t, err := net.Dial("tcp", targetAddr)
+	if err != nil {
+		return
+	}
+	defer t.Close()
+	go io.Copy(t, conn)
+	io.Copy(conn, t)
+}
+
+// socks5Request is the address a SOCKS5 stub was asked to connect to.
+type socks5Request struct {
+	atyp byte
+	host string
+	port uint16
+}
+
+// socks5Stub starts a SOCKS5 proxy relaying to targetAddr, requiring user
+// and pass unless user is empty. The requests it receives are sent to reqs.
+func socks5Stub(t *testing.T, targetAddr, user, pass string, reqs chan<- socks5Request) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return
+		}
+		methods := make([]byte, head[1])
+		if _, err := io.ReadFull(r, methods); err != nil {
+			return
+		}
+		want := byte(0x00)
+		if user != "" {
+			want = 0x02
+		}
+		if !strings.ContainsRune(string(methods), rune(want)) {
+			conn.Write([]byte{0x05, 0xff})
+			return
+		}
+		conn.Write([]byte{0x05, want})
+		if user != "" {
+			b := make([]byte, 2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			u := make([]byte, b[1])
+			io.ReadFull(r, u)
+			l, _ := r.ReadByte()
+			p := make([]byte, l)
+			io.ReadFull(r, p)
+			if string(u) != user || string(p) != pass {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		var host []byte
+		switch req[3] {
+		case 0x01:
+			host = make([]byte, net.IPv4len)
+		case 0x04:
+			host = make([]byte, net.IPv6len)
+		case 0x03:
+			l, _ := r.ReadByte()
+			host = make([]byte, l)
+		}
+		io.ReadFull(r, host)
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+		got := socks5Request{atyp: req[3], host: string(host), port: binary.BigEndian.Uint16(port)}
+		if req[3] != 0x03 {
+			got.host = net.IP(host).String()
+		}
+		reqs <- got
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		relayTo(struct {
+			io.Reader
+			io.Writer
+		}{r, conn}, targetAddr)
+	})
+}
+
+// httpStub starts an HTTP proxy relaying CONNECT requests to targetAddr. It
+// answers with status, and requires the basic credentials auth unless it is
+// empty.
+func httpStub(t *testing.T, targetAddr string, status int, auth string, reqs chan<- *http.Request) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		reqs <- req
+		switch {
+		case req.Method != http.MethodConnect:
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		case auth != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)):
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		case status != http.StatusOK:
+			fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+		default:
+			io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			relayTo(struct {
+				io.Reader
+				io.Writer
+			}{r, conn}, targetAddr)
+		}
+	})
+}
+
+// checkTunnel checks that conn reaches the target.
+func checkTunnel(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || line != banner {
+		t.Fatalf("got banner %q, %v", line, err)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, err = r.ReadString('\n'); err != nil || line != "ping\n" {
+		t.Fatalf("got echo %q, %v", line, err)
+	}
+}
+
+func TestDialSOCKS5(t *testing.T) {
+	targetAddr := target(t)
+	_, port, _ := net.SplitHostPort(targetAddr)
+	tests := []struct {
+		name     string
+		proxy    string // scheme and user info
+		host     string
+		wantAtyp byte // 0 for any IP address
+		wantHost string
+	}{
+		{"ip", "socks5://", "127.0.0.1", 0x01, "127.0.0.1"},
+		{"resolved locally", "socks5://", "localhost", 0, ""},
+		{"resolved by the proxy", "socks5h://", "localhost", 0x03, "localhost"},
+		{"ipv6", "socks5h://", "::1", 0x04, "::1"},
+		{"with credentials", "socks5h://deploy:s3cret@", "server.internal", 0x03, "server.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, pass := "", ""
+			if info, ok := strings.CutSuffix(strings.SplitN(tt.proxy, "//", 2)[1], "@"); ok {
+				user, pass, _ = strings.Cut(info, ":")
+			}
+			reqs := make(chan socks5Request, 1)
+			proxy := socks5Stub(t, targetAddr, user, pass, reqs)
+			conn, err := dialProxy(tt.proxy+proxy, net.JoinHostPort(tt.host, port), 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := <-reqs
+			if tt.wantAtyp == 0 && req.atyp == 0x03 {
+				t.Errorf("proxy asked to resolve %s", req.host)
+			}
+			if tt.wantAtyp != 0 && (req.atyp != tt.wantAtyp || req.host != tt.wantHost) {
+				t.Errorf("proxy asked for address type %d host %s, want %d %s", req.atyp, req.host, tt.wantAtyp, tt.wantHost)
+			}
+			if strconv.Itoa(int(req.port)) != port {
+				t.Errorf("proxy asked for port %d, want %s", req.port, port)
+			}
+			checkTunnel(t, conn)
+		})
+	}
+}
+
+func TestDialSOCKS5AuthFailure(t *testing.T) {
+	targetAddr := target(t)
+	tests := []struct {
+		name  string
+		proxy string
+		want  string
+	}{
+		{"wrong password", "socks5://deploy:wrong@", "proxy authentication failed"},
+		{"no credentials", "socks5://", "no acceptable authentication method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := socks5Stub(t, targetAddr, "deploy", "s3cret", make(chan socks5Request, 1))
+			_, err := dialProxy(tt.proxy+proxy, targetAddr, 5*time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialHTTP(t *testing.T) {
+	targetAddr := target(t)
+	tests := []struct {
+		name   string
+		proxy  string // scheme and user info
+		status int
+		auth   string
+		want   string // error, empty when connected
+	}{
+		{"connect", "http://", http.StatusOK, "", ""},
+		{"with credentials", "http://deploy:s3cret@", http.StatusOK, "deploy:s3cret", ""},
+		{"wrong credentials", "http://deploy:wrong@", http.StatusOK, "deploy:s3cret", "407 Proxy Authentication Required"},
+		{"no credentials", "http://", http.StatusOK, "deploy:s3cret", "407 Proxy Authentication Required"},
+		{"forbidden", "http://", http.StatusForbidden, "", "403 Forbidden"},
+		{"bad gateway", "http://", http.StatusBadGateway, "", "502 Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs := make(chan *http.Request, 1)
+			proxy := httpStub(t, targetAddr, tt.status, tt.auth, reqs)
+			conn, err := dialProxy(tt.proxy+proxy, targetAddr, 5*time.Second)
+			if req := <-reqs; req.Method != http.MethodConnect || req.Host != targetAddr {
+				t.Errorf("proxy got %s %s", req.Method, req.Host)
+			}
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("got error %v, want %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTunnel(t, conn)
+		})
+	}
+}
+
+func TestDialProxyInvalid(t *testing.T) {
+	for _, proxy := range []string{"ftp://proxy:21", "socks5://", "not a url"} {
+		if _, err := dialProxy(proxy, "server:22", time.Second); err == nil {
+			t.Errorf("%q accepted", proxy)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("ALL_PROXY", "socks5h://proxy:1080")
+	t.Setenv("HTTPS_PROXY", "http://other:3128")
+	t.Setenv("no_proxy", "example.com, .internal,LOCALHOST")
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"server.test", "socks5h://proxy:1080"},
+		{"example.com", ""},
+		{"www.example.com", ""},
+		{"notexample.com", "socks5h://proxy:1080"},
+		{"db.internal", ""},
+		{"internal", "socks5h://proxy:1080"},
+		{"localhost", ""},
+	}
+	for _, tt := range tests {
+		if got := proxyFromEnvironment(tt.host); got != tt.want {
+			t.Errorf("proxyFromEnvironment(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	t.Setenv("ALL_PROXY", "")
+	if got := proxyFromEnvironment("server.test"); got != "http://other:3128" {
+		t.Errorf("got %q, want HTTPS_PROXY", got)
+	}
+	t.Setenv("NO_PROXY", "*")
+	if got := proxyFromEnvironment("server.test"); got != "" {
+		t.Errorf("got %q with NO_PROXY=*", got)
+	}
+}
@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"encoding"
 	"fmt"
 	"io"
 	"os"
@@ -39,6 +40,20 @@ func (f fxp) String() string {
 		return "SSH_FXP_LSTAT"
 	case sshFxpFstat:
 		return "SSH_FXP_FSTAT"
+	case sshFxpSetstat:
+		return "SSH_FXP_SETSTAT"
+	case sshFxpFsetstat:
+		return "SSH_FXP_FSETSTAT"
+	case sshFxpRename:
+		return "SSH_FXP_RENAME"
+	case sshFxpReadlink:
+		return "SSH_FXP_READLINK"
+	case sshFxpSymlink:
+		return "SSH_FXP_SYMLINK"
+	case sshFxpExtended:
+		return "SSH_FXP_EXTENDED"
+	case sshFxpExtendedReply:
+		return "SSH_FXP_EXTENDED_REPLY"
 	default:
 		return "unknown"
 	}
@@ -88,6 +103,7 @@ type sftpclient struct {
 	maxPacket             uint32
 	maxConcurrentRequests int
 	useConcurrency        bool
+	extensions            map[string]string // announced by the server
 }
 
 func (s *sftpclient) Close() {
@@ -114,18 +130,30 @@ func (s *sftpclient) checkVersion() error {
 		return &unexpectedPacketErr{sshFxpVersion, typ}
 	}
 
-	version, _, err := unmarshalUint32Safe(data)
-	if err != nil {
+	// the version packet is laid out as the init packet
+	var version sshFxInitPacket
+	if err := version.UnmarshalBinary(data); err != nil {
 		return err
 	}
 
-	if version != sftpProtocolVersion {
-		return &unexpectedVersionErr{sftpProtocolVersion, version}
+	if version.Version != sftpProtocolVersion {
+		return &unexpectedVersionErr{sftpProtocolVersion, version.Version}
+	}
+
+	s.extensions = make(map[string]string)
+	for _, ext := range version.Extensions {
+		s.extensions[ext.Name] = ext.Data
 	}
 
 	return nil
 }
 
+// HasExtension reports whether the server supports the extension name.
+func (s *sftpclient) HasExtension(name string) bool {
+	_, ok := s.extensions[name]
+	return ok
+}
+
 func (s *sftpclient) RealPath(path string) (string, error) {
 	id := s.nextID()
 	if err := s.sftpconn.sendPacket(&sshFxpRealpathPacket{ID: id, Path: path}); err != nil {
@@ -156,7 +184,17 @@ func (s *sftpclient) RealPath(path string) (string, error) {
 
 func (s *sftpclient) Stat(path string) (*fileStat, error) {
 	id := s.nextID()
-	if err := s.sftpconn.sendPacket(&sshFxpStatPacket{ID: id, Path: path}); err != nil {
+	return s.stat(id, &sshFxpStatPacket{ID: id, Path: path})
+}
+
+// Lstat returns the attributes of path without following a symbolic link.
+func (s *sftpclient) Lstat(path string) (*fileStat, error) {
+	id := s.nextID()
+	return s.stat(id, &sshFxpLstatPacket{ID: id, Path: path})
+}
+
+func (s *sftpclient) stat(id uint32, packet encoding.BinaryMarshaler) (*fileStat, error) {
+	if err := s.sftpconn.sendPacket(packet); err != nil {
 		return nil, err
 	}
 	typ, data, err := s.recvPacket()
@@ -181,6 +219,53 @@ func (s *sftpclient) Stat(path string) (*fileStat, error) {
 	}
 }
 
+// ReadLink returns the target of the symbolic link path.
+func (s *sftpclient) ReadLink(path string) (string, error) {
+	id := s.nextID()
+	if err := s.sftpconn.sendPacket(&sshFxpReadlinkPacket{ID: id, Path: path}); err != nil {
+		return "", err
+	}
+	typ, data, err := s.recvPacket()
+	if err != nil {
+		return "", err
+	}
+	switch typ {
+	case sshFxpName:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return "", &unexpectedIDErr{id, sid}
+		}
+		count, data := unmarshalUint32(data)
+		if count != 1 {
+			return "", unexpectedCount(1, count)
+		}
+		target, _ := unmarshalString(data) // ignore attributes
+		return target, nil
+	case sshFxpStatus:
+		return "", normaliseError(unmarshalStatus(id, data))
+	default:
+		return "", unimplementedPacketErr(typ)
+	}
+}
+
+// Symlink creates the symbolic link linkpath pointing to target.
+func (s *sftpclient) Symlink(target, linkpath string) error {
+	id := s.nextID()
+	if err := s.sftpconn.sendPacket(&sshFxpSymlinkPacket{ID: id, Targetpath: target, Linkpath: linkpath}); err != nil {
+		return err
+	}
+	typ, data, err := s.recvPacket()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
 func (s *sftpclient) IsRegular(path string) (bool, error) {
 	attr, err := s.Stat(path)
 	if err != nil {
@@ -197,9 +282,9 @@ func (s *sftpclient) IsDir(path string) (bool, error) {
 	return attr.IsDir(), nil
 }
 
-func (s *sftpclient) open(path string, pflags uint32) (*file, error) {
+func (s *sftpclient) open(path string, pflags uint32, attrs fileAttrs) (*file, error) {
 	id := s.nextID()
-	if err := s.sftpconn.sendPacket(&sshFxpOpenPacket{ID: id, Path: path, Pflags: pflags}); err != nil {
+	if err := s.sftpconn.sendPacket(&sshFxpOpenPacket{ID: id, Path: path, Pflags: pflags, Attrs: attrs}); err != nil {
 		return nil, err
 	}
 	typ, data, err := s.recvPacket()
@@ -222,16 +307,46 @@ func (s *sftpclient) open(path string, pflags uint32) (*file, error) {
 }
 
 func (s *sftpclient) Open(path string) (*file, error) {
-	return s.open(path, sshFxfRead)
+	return s.open(path, sshFxfRead, fileAttrs{})
 }
 
 func (s *sftpclient) Create(path string) (*file, error) {
-	return s.open(path, sshFxfRead|sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+	return s.open(path, sshFxfRead|sshFxfWrite|sshFxfCreat|sshFxfTrunc, fileAttrs{})
+}
+
+// OpenFile opens path with the sshFxf flags, a file it creates gets the
+// permission bits perm, or the server default when perm is 0.
+func (s *sftpclient) OpenFile(path string, pflags uint32, perm uint32) (*file, error) {
+	return s.open(path, pflags, permAttrs(perm))
 }
 
 func (s *sftpclient) Mkdir(path string) error {
+	return s.MkdirMode(path, 0)
+}
+
+// MkdirMode creates a directory with the permission bits perm, or the
+// server default when perm is 0.
+func (s *sftpclient) MkdirMode(path string, perm uint32) error {
 	id := s.nextID()
-	if err := s.sftpconn.sendPacket(&sshFxpMkdirPacket{ID: id, Path: path}); err != nil {
+	if err := s.sftpconn.sendPacket(&sshFxpMkdirPacket{ID: id, Path: path, Attrs: permAttrs(perm)}); err != nil {
+		return err
+	}
+	typ, data, err := s.recvPacket()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// SetStat changes the attributes of path selected by attrs.flags.
+func (s *sftpclient) SetStat(path string, attrs fileAttrs) error {
+	id := s.nextID()
+	if err := s.sftpconn.sendPacket(&sshFxpSetstatPacket{ID: id, Path: path, Attrs: attrs}); err != nil {
 		return err
 	}
 	typ, data, err := s.recvPacket()
@@ -372,6 +487,98 @@ func (s *sftpclient) RemoveFile(path string) error {
 	}
 }
 
+// Rename renames oldpath to newpath, which must not exist.
+func (s *sftpclient) Rename(oldpath, newpath string) error {
+	id := s.nextID()
+	return s.rename(id, &sshFxpRenamePacket{ID: id, Oldpath: oldpath, Newpath: newpath})
+}
+
+// PosixRename renames oldpath to newpath, replacing newpath atomically. The
+// server must support extPosixRename.
+func (s *sftpclient) PosixRename(oldpath, newpath string) error {
+	id := s.nextID()
+	return s.rename(id, &sshFxpPosixRenamePacket{ID: id, Oldpath: oldpath, Newpath: newpath})
+}
+
+func (s *sftpclient) rename(id uint32, packet encoding.BinaryMarshaler) error {
+	if err := s.sendPacket(packet); err != nil {
+		return err
+	}
+	typ, data, err := s.recvPacket()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// Replace renames oldpath over newpath, atomically when the server supports
+// extPosixRename. Otherwise newpath is removed first, as SSH_FXP_RENAME
+// does not replace it.
+func (s *sftpclient) Replace(oldpath, newpath string) error {
+	if s.HasExtension(extPosixRename) {
+		return s.PosixRename(oldpath, newpath)
+	}
+	err := s.Rename(oldpath, newpath)
+	if err == nil {
+		return nil
+	}
+	if _, serr := s.Lstat(newpath); serr != nil {
+		return err
+	}
+	if err := s.RemoveFile(newpath); err != nil {
+		return err
+	}
+	return s.Rename(oldpath, newpath)
+}
+
+// CheckFile returns the hash alg of the file path, computed by the server. The
+// server must support extCheckFile.
+func (s *sftpclient) CheckFile(path, alg string) ([]byte, error) {
+	id := s.nextID()
+	if err := s.sendPacket(&sshFxpCheckFileNamePacket{ID: id, Path: path, Algs: alg}); err != nil {
+		return nil, err
+	}
+	typ, data, err := s.recvPacket()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		// string "check-file", string hash-algo-used, byte[] hash
+		name, data, err := unmarshalStringSafe(data)
+		if err != nil {
+			return nil, err
+		}
+		if name != extCheckFile {
+			return nil, fmt.Errorf("sftp: unexpected extended reply %q to check-file", name)
+		}
+		used, sum, err := unmarshalStringSafe(data)
+		if err != nil {
+			return nil, err
+		}
+		if used != alg {
+			return nil, fmt.Errorf("sftp: check-file hashed with %s, not %s", used, alg)
+		}
+		return sum, nil
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
 func (s *sftpclient) RemoveDirectory(path string) error {
 	id := s.nextID()
 	if err := s.sendPacket(&sshFxpRmdirPacket{
@@ -7,6 +7,7 @@ import (
 	"math"
 	"os"
 	"sync"
+	"time"
 )
 
 const (
@@ -21,6 +22,7 @@ const (
 	modeDir     uint32 = 0x4000 // S_IFDIR
 	modeType    uint32 = 0xF000 // S_IFMT
 	modeRegular uint32 = 0x8000 // S_IFREG
+	modeSymlink uint32 = 0xA000 // S_IFLNK
 )
 
 type fileInfo struct {
@@ -43,13 +45,71 @@ type statExtended struct {
 }
 
 func (fs *fileStat) IsDir() bool {
-	return fs.mode&modeDir == modeDir
+	return fs.mode&modeType == modeDir
 }
 
 func (fs *fileStat) IsRegular() bool {
 	return fs.mode&modeType == modeRegular
 }
 
+func (fs *fileStat) IsSymlink() bool {
+	return fs.mode&modeType == modeSymlink
+}
+
+// setLocal copies the permission bits and times of fs, when the server sent
+// them, to a local file.
+func (fs *fileStat) setLocal(name string) error {
+	if fs.mode != 0 {
+		if err := os.Chmod(name, fileMode(fs.mode)); err != nil {
+			return err
+		}
+	}
+	if fs.mtime != 0 {
+		return os.Chtimes(name, time.Unix(int64(fs.atime), 0), time.Unix(int64(fs.mtime), 0))
+	}
+	return nil
+}
+
+// unixPerm returns the permission bits of mode as sent to the server.
+func unixPerm(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+	return perm
+}
+
+// fileMode returns the os.FileMode of the permission bits sent by the server.
+func fileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0o777)
+	if perm&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// localAttrs returns the permission bits and times of a local file.
+func localAttrs(fi os.FileInfo) fileAttrs {
+	return fileAttrs{
+		flags: sshFileXferAttrPermissions | sshFileXferAttrACmodTime,
+		perm:  unixPerm(fi.Mode()),
+		atime: uint32(accessTime(fi).Unix()),
+		mtime: uint32(fi.ModTime().Unix()),
+	}
+}
+
 type file struct {
 	c    *sftpclient
 	path string
@@ -86,6 +146,31 @@ func (f *file) close() error {
 	}
 }
 
+// setStat changes the attributes of the open file selected by attrs.flags.
+func (f *file) setStat(attrs fileAttrs) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.handle == "" {
+		return os.ErrClosed
+	}
+
+	id := f.c.nextID()
+	if err := f.c.sendPacket(&sshFxpFsetstatPacket{ID: id, Handle: f.handle, Attrs: attrs}); err != nil {
+		return err
+	}
+
+	typ, data, err := f.c.recvPacket()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
 func (f *file) writeChunkAt(b []byte, off uint64) (int, error) {
 	if err := f.c.sendPacket(&sshFxpWritePacket{
 		ID:     f.c.nextID(),
@@ -97,6 +182,10 @@ func (f *file) writeChunkAt(b []byte, off uint64) (int, error) {
 		return 0, err
 	}
 	typ, data, err := f.c.recvPacket()
+	if err == io.EOF {
+		// readFrom stops without error at io.EOF of its reader
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -115,13 +204,16 @@ func (f *file) writeChunkAt(b []byte, off uint64) (int, error) {
 func (f *file) readFrom(r io.Reader, size int64, useConcurrency bool) (int64, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	if useConcurrency {
-		return f.readFromConcurrency(r, size)
-	}
 	if f.handle == "" {
 		return 0, os.ErrClosed
 	}
+	if useConcurrency {
+		return f.readFromConcurrency(r, size)
+	}
+	return f.readFromSequential(r)
+}
 
+func (f *file) readFromSequential(r io.Reader) (int64, error) {
 	b := make([]byte, f.c.maxPacket)
 	var read int64
 	for {
@@ -183,6 +275,16 @@ func (f *file) readChunkAt(b []byte, off uint64) (n int, err error) {
 	return
 }
 
+// ReadAt reads len(b) bytes at off, as io.ReaderAt.
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.handle == "" {
+		return 0, os.ErrClosed
+	}
+	return f.readChunkAt(b, uint64(off))
+}
+
 func (f *file) writeToSequential(W io.Writer) (written int64, err error) {
 	b := make([]byte, f.c.maxPacket)
 
@@ -299,7 +401,7 @@ func (f *sftpFilePacket) recvPacket() {
 func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 
 	if size <= int64(f.c.maxPacket) {
-		return f.readFrom(r, size, false)
+		return f.readFromSequential(r)
 	}
 	concurrency64 := size/int64(f.c.maxPacket) + 1
 	concurrency := int(min(concurrency64, int64(f.c.maxConcurrentRequests)))
@@ -323,9 +425,14 @@ func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 
 	b := make([]byte, f.c.maxPacket)
 	var read uint64
-	go filePacket.recvPacket()
+	received := make(chan struct{})
+	go func() {
+		filePacket.recvPacket()
+		close(received)
+	}()
 	defer func() {
-		// defer to close the recvPacket routine
+		// defer to close the recvPacket routine, which must stop reading
+		// before the next request on the connection
 		f.c.sendPacket(&sshFxpWritePacket{
 			ID:     f.c.nextID(),
 			Handle: f.handle,
@@ -333,10 +440,11 @@ func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 			Length: 0,
 			Data:   []byte{},
 		})
+		<-received
 	}()
 	go func() {
 		defer close(worker)
-		var off uint64
+		off := f.offset
 		for {
 			n, err := r.Read(b)
 			if n < 0 {
@@ -345,7 +453,7 @@ func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 
 			if n > 0 {
 				read += uint64(n)
-				ch := make(chan result)
+				ch := make(chan result, 1)
 				id := f.c.nextID()
 				worker <- work{id: id, res: ch, off: off}
 				filePacket.sendPacket(id, ch, &sshFxpWritePacket{
@@ -365,7 +473,7 @@ func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 
 			if err != nil {
 				if err != io.EOF {
-					errCh <- rwErr{off: f.offset, err: err}
+					errCh <- rwErr{off: off, err: err}
 				}
 				return
 			}
@@ -389,7 +497,7 @@ func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 					}
 				}
 				if err != nil {
-					errCh <- rwErr{off: w.off, err: res.err}
+					errCh <- rwErr{off: w.off, err: err}
 				}
 			}
 		}()
@@ -423,7 +531,7 @@ func (f *file) readFromConcurrency(r io.Reader, size int64) (int64, error) {
 func (f *file) writeToConcurrency(w io.Writer, size int64) (int64, error) {
 
 	if size <= int64(f.c.maxPacket) {
-		return f.writeTo(w, size, false)
+		return f.writeToSequential(w)
 	}
 
 	concurrency64 := size/int64(f.c.maxPacket) + 1
@@ -450,14 +558,20 @@ func (f *file) writeToConcurrency(w io.Writer, size int64) (int64, error) {
 	cancel := make(chan struct{})
 	worker := make(chan readWork, concurrency)
 	readCh := make(chan writeWork)
-	go filePacket.recvPacket()
+	sending := make(chan struct{})
+	received := make(chan struct{})
+	go func() {
+		filePacket.recvPacket()
+		close(received)
+	}()
 	go func() {
+		defer close(sending)
 		defer close(worker)
 		off := uint64(f.offset)
 		cur := readCh
 		for {
 			next := make(chan writeWork)
-			ch := make(chan result)
+			ch := make(chan result, 1)
 			id := f.c.nextID()
 			readWork := readWork{
 				id:   id,
@@ -466,7 +580,11 @@ func (f *file) writeToConcurrency(w io.Writer, size int64) (int64, error) {
 				curr: cur,
 				next: next,
 			}
-			worker <- readWork
+			select {
+			case worker <- readWork:
+			case <-cancel:
+				return
+			}
 			filePacket.sendPacket(id, ch, &sshFxpReadPacket{
 				ID:     id,
 				Handle: f.handle,
@@ -527,13 +645,16 @@ func (f *file) writeToConcurrency(w io.Writer, size int64) (int64, error) {
 	}
 	defer func() {
 		close(cancel)
-		// defer to close the recvPacket routine
+		// no read may follow the one closing the recvPacket routine, which
+		// must stop reading before the next request on the connection
+		<-sending
 		f.c.sendPacket(&sshFxpReadPacket{
 			ID:     f.c.nextID(),
 			Handle: f.handle,
 			Offset: 0,
 			Len:    0,
 		})
+		<-received
 		wg.Wait()
 	}()
 	var (
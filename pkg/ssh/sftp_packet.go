@@ -18,6 +18,8 @@ const (
 	sshFxpWrite    = 6
 	sshFxpLstat    = 7
 	sshFxpFstat    = 8
+	sshFxpSetstat  = 9
+	sshFxpFsetstat = 10
 	sshFxpOpendir  = 11
 	sshFxpReaddir  = 12
 	sshFxpRemove   = 13
@@ -25,13 +27,27 @@ const (
 	sshFxpMkdir    = 14
 	sshFxpRealpath = 16
 	sshFxpStat     = 17
+	sshFxpRename   = 18
+	sshFxpReadlink = 19
+	sshFxpSymlink  = 20
 	sshFxpStatus   = 101
 	sshFxpHandle   = 102
 	sshFxpData     = 103
 	sshFxpName     = 104
 	sshFxpAttrs    = 105
+	sshFxpExtended = 200
+
+	sshFxpExtendedReply = 201
 )
 
+// extPosixRename renames over an existing file, which SSH_FXP_RENAME does
+// not.
+const extPosixRename = "posix-rename@openssh.com"
+
+// extCheckFile hashes a file on the server, requested by name with
+// "check-file-name".
+const extCheckFile = "check-file"
+
 const (
 	sshFxOk               = 0
 	sshFxEOF              = 1
@@ -249,6 +265,70 @@ func unmarshalFileStat(flags uint32, b []byte) (*fileStat, []byte, error) {
 	return &fs, b, nil
 }
 
+// fileAttrs are the attributes sent to the server, flags selects the ones
+// that are set.
+type fileAttrs struct {
+	flags uint32
+	uid   uint32
+	gid   uint32
+	perm  uint32
+	atime uint32
+	mtime uint32
+}
+
+func (a fileAttrs) len() int {
+	l := 4
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		l += 8
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		l += 4
+	}
+	if a.flags&sshFileXferAttrACmodTime != 0 {
+		l += 8
+	}
+	return l
+}
+
+func marshalAttrs(b []byte, a fileAttrs) []byte {
+	b = marshalUint32(b, a.flags)
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		b = marshalUint32(b, a.uid)
+		b = marshalUint32(b, a.gid)
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		b = marshalUint32(b, a.perm)
+	}
+	if a.flags&sshFileXferAttrACmodTime != 0 {
+		b = marshalUint32(b, a.atime)
+		b = marshalUint32(b, a.mtime)
+	}
+	return b
+}
+
+func unmarshalFileAttrs(b []byte) (fileAttrs, []byte, error) {
+	fs, b, err := unmarshalAttrs(b)
+	if err != nil {
+		return fileAttrs{}, b, err
+	}
+	return fileAttrs{
+		flags: sshFileXferAttrUIDGID | sshFileXferAttrPermissions | sshFileXferAttrACmodTime,
+		uid:   fs.uid,
+		gid:   fs.gid,
+		perm:  fs.mode,
+		atime: fs.atime,
+		mtime: fs.mtime,
+	}, b, nil
+}
+
+// permAttrs sets only the permission bits.
+func permAttrs(perm uint32) fileAttrs {
+	if perm == 0 {
+		return fileAttrs{}
+	}
+	return fileAttrs{flags: sshFileXferAttrPermissions, perm: perm}
+}
+
 func unmarshalStatus(id uint32, data []byte) error {
 	sid, data := unmarshalUint32(data)
 	if sid != id {
@@ -312,21 +392,20 @@ type sshFxpOpenPacket struct {
 	ID     uint32
 	Path   string
 	Pflags uint32
-	Flags  uint32
-	Attrs  interface{}
+	Attrs  fileAttrs
 }
 
 func (p *sshFxpOpenPacket) marshalPacket() ([]byte, []byte, error) {
 	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
 		4 + len(p.Path) +
-		4 + 4
+		4 + p.Attrs.len()
 
 	b := make([]byte, 4, l)
 	b = append(b, sshFxpOpen)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Path)
 	b = marshalUint32(b, p.Pflags)
-	b = marshalUint32(b, p.Flags)
+	b = marshalAttrs(b, p.Attrs)
 
 	return b, nil, nil
 }
@@ -344,11 +423,9 @@ func (p *sshFxpOpenPacket) UnmarshalBinary(b []byte) error {
 		return err
 	} else if p.Pflags, b, err = unmarshalUint32Safe(b); err != nil {
 		return err
-	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
 	}
-	p.Attrs = b
-	return nil
+	p.Attrs, _, err = unmarshalFileAttrs(b)
+	return err
 }
 
 type sshFxpReadPacket struct {
@@ -436,20 +513,20 @@ func (p *sshFxpWritePacket) UnmarshalBinary(b []byte) error {
 
 type sshFxpMkdirPacket struct {
 	ID    uint32
-	Flags uint32 // ignored
 	Path  string
+	Attrs fileAttrs
 }
 
 func (p *sshFxpMkdirPacket) MarshalBinary() ([]byte, error) {
 	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
 		4 + len(p.Path) +
-		4 // uint32
+		p.Attrs.len()
 
 	b := make([]byte, 4, l)
 	b = append(b, sshFxpMkdir)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Path)
-	b = marshalUint32(b, p.Flags)
+	b = marshalAttrs(b, p.Attrs)
 
 	return b, nil
 }
@@ -460,10 +537,71 @@ func (p *sshFxpMkdirPacket) UnmarshalBinary(b []byte) error {
 		return err
 	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
 		return err
-	} else if p.Flags, _, err = unmarshalUint32Safe(b); err != nil {
+	}
+	p.Attrs, _, err = unmarshalFileAttrs(b)
+	return err
+}
+
+type sshFxpSetstatPacket struct {
+	ID    uint32
+	Path  string
+	Attrs fileAttrs
+}
+
+func (p *sshFxpSetstatPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Path) +
+		p.Attrs.len()
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpSetstat)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Path)
+	b = marshalAttrs(b, p.Attrs)
+
+	return b, nil
+}
+
+func (p *sshFxpSetstatPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
 		return err
 	}
-	return nil
+	p.Attrs, _, err = unmarshalFileAttrs(b)
+	return err
+}
+
+type sshFxpFsetstatPacket struct {
+	ID     uint32
+	Handle string
+	Attrs  fileAttrs
+}
+
+func (p *sshFxpFsetstatPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Handle) +
+		p.Attrs.len()
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpFsetstat)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Handle)
+	b = marshalAttrs(b, p.Attrs)
+
+	return b, nil
+}
+
+func (p *sshFxpFsetstatPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	p.Attrs, _, err = unmarshalFileAttrs(b)
+	return err
 }
 
 type sshFxpOpendirPacket struct {
@@ -505,6 +643,150 @@ func (p *sshFxpStatPacket) UnmarshalBinary(b []byte) error {
 	return unmarshalIDString(b, &p.ID, &p.Path)
 }
 
+type sshFxpLstatPacket struct {
+	ID   uint32
+	Path string
+}
+
+func (p *sshFxpLstatPacket) MarshalBinary() ([]byte, error) {
+	return marshalIDStringPacket(sshFxpLstat, p.ID, p.Path)
+}
+
+func (p *sshFxpLstatPacket) UnmarshalBinary(b []byte) error {
+	return unmarshalIDString(b, &p.ID, &p.Path)
+}
+
+type sshFxpReadlinkPacket struct {
+	ID   uint32
+	Path string
+}
+
+func (p *sshFxpReadlinkPacket) MarshalBinary() ([]byte, error) {
+	return marshalIDStringPacket(sshFxpReadlink, p.ID, p.Path)
+}
+
+func (p *sshFxpReadlinkPacket) UnmarshalBinary(b []byte) error {
+	return unmarshalIDString(b, &p.ID, &p.Path)
+}
+
+// sshFxpSymlinkPacket sends the target before the link path, as OpenSSH
+// does, which swapped the order of the draft.
+type sshFxpSymlinkPacket struct {
+	ID         uint32
+	Targetpath string
+	Linkpath   string
+}
+
+func (p *sshFxpSymlinkPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Targetpath) +
+		4 + len(p.Linkpath)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpSymlink)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Targetpath)
+	b = marshalString(b, p.Linkpath)
+
+	return b, nil
+}
+
+func (p *sshFxpSymlinkPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.Targetpath, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Linkpath, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpRenamePacket struct {
+	ID      uint32
+	Oldpath string
+	Newpath string
+}
+
+func (p *sshFxpRenamePacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Oldpath) +
+		4 + len(p.Newpath)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpRename)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Oldpath)
+	b = marshalString(b, p.Newpath)
+
+	return b, nil
+}
+
+func (p *sshFxpRenamePacket) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.Oldpath, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Newpath, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpPosixRenamePacket struct {
+	ID      uint32
+	Oldpath string
+	Newpath string
+}
+
+func (p *sshFxpPosixRenamePacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(extPosixRename) +
+		4 + len(p.Oldpath) +
+		4 + len(p.Newpath)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, extPosixRename)
+	b = marshalString(b, p.Oldpath)
+	b = marshalString(b, p.Newpath)
+
+	return b, nil
+}
+
+type sshFxpCheckFileNamePacket struct {
+	ID        uint32
+	Path      string
+	Algs      string // comma separated, in order of preference
+	Offset    uint64
+	Length    uint64 // 0 up to the end of the file
+	BlockSize uint32 // 0 for a single hash
+}
+
+func (p *sshFxpCheckFileNamePacket) MarshalBinary() ([]byte, error) {
+	const name = "check-file-name"
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(name) +
+		4 + len(p.Path) +
+		4 + len(p.Algs) +
+		8 + 8 + 4 // uint64(offset) + uint64(length) + uint32(block size)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, name)
+	b = marshalString(b, p.Path)
+	b = marshalString(b, p.Algs)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+	b = marshalUint32(b, p.BlockSize)
+
+	return b, nil
+}
+
 type sshFxpClosePacket struct {
 	ID     uint32
 	Handle string
@@ -0,0 +1,215 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+type discardCloser struct{ io.Writer }
+
+func (discardCloser) Close() error { return nil }
+
+// replyClient returns a client reading the packet typ with id 1 and data as
+// the reply to its first request.
+func replyClient(typ byte, data []byte) *sftpclient {
+	p := marshalUint32(nil, uint32(1+4+len(data)))
+	p = append(p, typ)
+	p = marshalUint32(p, 1)
+	p = append(p, data...)
+	return &sftpclient{sftpconn: sftpconn{reader: bytes.NewReader(p), write: discardCloser{io.Discard}}}
+}
+
+// errUnsupported is answered by sftpServer to the requests it does not serve.
+var errUnsupported = errors.New("unsupported")
+
+// sftpServer returns a client whose requests are served from the local file
+// system. Files cannot be created in the directories denied.
+func sftpServer(t *testing.T, denied ...string) *sftpclient {
+	requests, client := io.Pipe()
+	replies, server := io.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		defer server.Close()
+		conn := sftpconn{reader: requests}
+		files := make(map[string]*os.File)
+		for {
+			typ, data, err := conn.recvPacket()
+			if err != nil {
+				return
+			}
+			id, data := unmarshalUint32(data)
+			reply := func(typ byte, b []byte) {
+				p := marshalUint32(nil, uint32(1+4+len(b)))
+				p = append(p, typ)
+				p = marshalUint32(p, id)
+				server.Write(append(p, b...))
+			}
+			status := func(err error) {
+				code := uint32(sshFxOk)
+				switch {
+				case err == io.EOF:
+					code = sshFxEOF
+				case errors.Is(err, os.ErrNotExist):
+					code = sshFxNoSuchFile
+				case errors.Is(err, os.ErrPermission):
+					code = sshFxPermissionDenied
+				case errors.Is(err, errUnsupported):
+					code = sshFxOPUnsupported
+				case err != nil:
+					code = sshFxFailure
+				}
+				b := marshalUint32(nil, code)
+				b = marshalString(b, "")
+				reply(sshFxpStatus, marshalString(b, ""))
+			}
+			switch typ {
+			case sshFxpStat, sshFxpLstat:
+				name, _ := unmarshalString(data)
+				stat := os.Stat
+				if typ == sshFxpLstat {
+					stat = os.Lstat
+				}
+				fi, err := stat(name)
+				if err != nil {
+					status(err)
+					continue
+				}
+				mode := unixPerm(fi.Mode()) | modeRegular
+				switch {
+				case fi.IsDir():
+					mode = unixPerm(fi.Mode()) | modeDir
+				case fi.Mode()&os.ModeSymlink != 0:
+					mode = unixPerm(fi.Mode()) | modeSymlink
+				}
+				b := marshalUint32(nil, sshFileXferAttrSize|sshFileXferAttrPermissions)
+				b = marshalUint64(b, uint64(fi.Size()))
+				reply(sshFxpAttrs, marshalUint32(b, mode))
+			case sshFxpRealpath:
+				name, _ := unmarshalString(data)
+				b := marshalUint32(nil, 1)
+				b = marshalString(b, name)
+				b = marshalString(b, name)
+				reply(sshFxpName, marshalAttrs(b, fileAttrs{}))
+			case sshFxpRead:
+				handle, data := unmarshalString(data)
+				off, data := unmarshalUint64(data)
+				n, _ := unmarshalUint32(data)
+				b := make([]byte, n)
+				m, err := files[handle].ReadAt(b, int64(off))
+				if m == 0 {
+					status(err)
+					continue
+				}
+				reply(sshFxpData, marshalString(nil, string(b[:m])))
+			case sshFxpReadlink:
+				name, _ := unmarshalString(data)
+				target, err := os.Readlink(name)
+				if err != nil {
+					status(err)
+					continue
+				}
+				b := marshalUint32(nil, 1)
+				b = marshalString(b, target)
+				b = marshalString(b, target)
+				reply(sshFxpName, marshalAttrs(b, fileAttrs{}))
+			case sshFxpOpen:
+				name, data := unmarshalString(data)
+				pflags, _ := unmarshalUint32(data)
+				flag := os.O_RDONLY
+				if pflags&sshFxfWrite != 0 {
+					flag = os.O_RDWR
+				}
+				if pflags&sshFxfCreat != 0 {
+					flag |= os.O_CREATE
+					if _, err := os.Stat(name); err != nil && slices.Contains(denied, filepath.Dir(name)) {
+						status(os.ErrPermission)
+						continue
+					}
+				}
+				if pflags&sshFxfTrunc != 0 {
+					flag |= os.O_TRUNC
+				}
+				f, err := os.OpenFile(name, flag, 0o644)
+				if err != nil {
+					status(err)
+					continue
+				}
+				handle := strconv.Itoa(int(id))
+				files[handle] = f
+				reply(sshFxpHandle, marshalString(nil, handle))
+			case sshFxpWrite:
+				handle, data := unmarshalString(data)
+				off, data := unmarshalUint64(data)
+				n, data := unmarshalUint32(data)
+				_, err := files[handle].WriteAt(data[:n], int64(off))
+				status(err)
+			case sshFxpFsetstat:
+				handle, data := unmarshalString(data)
+				flags, _ := unmarshalUint32(data)
+				attrs, _, _ := unmarshalAttrs(data)
+				var err error
+				if flags&sshFileXferAttrPermissions != 0 {
+					err = files[handle].Chmod(fileMode(attrs.mode))
+				}
+				status(err)
+			case sshFxpClose:
+				handle, _ := unmarshalString(data)
+				err := files[handle].Close()
+				delete(files, handle)
+				status(err)
+			case sshFxpRemove:
+				name, _ := unmarshalString(data)
+				status(os.Remove(name))
+			case sshFxpExtended:
+				ext, data := unmarshalString(data)
+				if ext != extPosixRename {
+					status(errUnsupported)
+					continue
+				}
+				oldpath, data := unmarshalString(data)
+				newpath, _ := unmarshalString(data)
+				status(os.Rename(oldpath, newpath))
+			default:
+				status(errUnsupported)
+			}
+		}
+	}()
+	return &sftpclient{
+		sftpconn:              sftpconn{reader: replies, write: client},
+		maxPacket:             32768,
+		maxConcurrentRequests: 1,
+		extensions:            map[string]string{extPosixRename: "1"},
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	want := sha256.Sum256([]byte("content"))
+	reply := marshalString(nil, extCheckFile)
+	reply = marshalString(reply, VerifySHA256)
+	reply = append(reply, want[:]...)
+
+	sum, err := replyClient(sshFxpExtendedReply, reply).CheckFile("/file", VerifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sum, want[:]) {
+		t.Errorf("got %x, want %x", sum, want)
+	}
+}
+
+func TestCheckFileOtherHash(t *testing.T) {
+	reply := marshalString(nil, extCheckFile)
+	reply = marshalString(reply, "md5")
+	reply = append(reply, make([]byte, 16)...)
+
+	if _, err := replyClient(sshFxpExtendedReply, reply).CheckFile("/file", VerifySHA256); err == nil {
+		t.Error("md5 reply accepted for sha256")
+	}
+}
@@ -2,6 +2,7 @@ package ssh
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,8 +13,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/san-gg/mdeploy/pkg/credential"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type FileStat interface {
@@ -28,54 +30,142 @@ type SshSession interface {
 	Exec(cmdOutput io.Writer, cmd string, param ...string) error
 	ReceiveRemoteFile(progress io.Writer, remoteSrc, dst string) error
 	ReceiveRemoteDir(progress io.Writer, remoteDir, dst string) error
+	SyncToRemote(progress io.Writer, src, dst string) error
+	SyncFromRemote(progress io.Writer, remoteSrc, dst string) error
 	RemoveFile(path string) error
 	Mkdir(path string) error
 	RemoveDirectory(path string) error
 	RemoveAll(srcdir string) error
 	SetSftpConcurrency(concurrency bool)
+	SetTransferOptions(opt TransferOptions)
+	Forward(f Forward) (net.Listener, error)
+	InstallPublicKey(line []byte) (bool, error)
+	Connected() bool
+	Reconnect() error
 	Close()
 }
 
-const knownhostFile = ".known_hosts"
+type sftpFunc func(progress *progressCopy, src, dest string) error
 
-var knownHostKeyCallback ssh.HostKeyCallback
+// sshConn is an authenticated connection to a server, reached through the
+// jump hosts in order. done is closed once the connection is lost.
+type sshConn struct {
+	client       *ssh.Client
+	jumps        []*ssh.Client
+	forwardAgent bool
+	done         chan struct{}
+}
 
-type sftpFunc func(progress *progressCopy, src, dest string) error
+func (c *sshConn) Close() {
+	c.client.Close()
+	for i := len(c.jumps) - 1; i >= 0; i-- {
+		c.jumps[i].Close()
+	}
+}
+
+// connSource provides the connection of a session. get returns a working
+// connection, replacing broken when it is not nil.
+type connSource interface {
+	get(opt Options, broken *sshConn) (*sshConn, error)
+}
+
+// connOwner is a connSource whose connections belong to a single session,
+// put closes them once the session is done with them.
+type connOwner interface {
+	put(conn *sshConn)
+}
+
+// ownConn dials a connection used by a single session.
+type ownConn func(Options) (*sshConn, error)
+
+func (d ownConn) get(opt Options, broken *sshConn) (*sshConn, error) {
+	if broken != nil {
+		broken.Close()
+	}
+	return d(opt)
+}
+
+func (d ownConn) put(conn *sshConn) {
+	conn.Close()
+}
 
 type sshSession struct {
-	sftp            *sftpclient
-	client          *ssh.Client
-	trustServerHost bool
+	sftp     *sftpclient
+	conn     *sshConn
+	opt      Options
+	transfer TransferOptions
+	owner    *owner // uid and gid of transfer.Owner and transfer.Group
+	source   connSource
+}
+
+func newSession(source connSource, opt Options) (*sshSession, error) {
+	s := &sshSession{opt: opt, source: source}
+	if err := s.open(nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open gets a connection and starts the SFTP subsystem on it.
+func (s *sshSession) open(broken *sshConn) error {
+	conn, err := s.source.get(s.opt, broken)
+	if err != nil {
+		return err
+	}
+	sftp, err := NewSFTPClient(conn.client, s.opt.SftpConcurrency)
+	if err != nil {
+		s.release(conn)
+		return err
+	}
+	s.conn = conn
+	s.sftp = sftp
+	return nil
 }
 
 func (s *sshSession) Close() {
-	s.client.Close()
 	s.sftp.Close()
+	s.release(s.conn)
 }
 
-func (s *sshSession) serverHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	err := knownHostKeyCallback(hostname, remote, key)
-	if err != nil && s.trustServerHost {
-		file, err := os.OpenFile(knownhostFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			return fmt.Errorf("failed to open known hosts file")
-		}
-		defer file.Close()
+// release hands conn back to its source once the session is done with it.
+// Shared connections stay open for other sessions.
+func (s *sshSession) release(conn *sshConn) {
+	if owner, ok := s.source.(connOwner); ok {
+		owner.put(conn)
+	}
+}
 
-		line := knownhosts.Line([]string{hostname}, key)
-		_, err = file.WriteString(line + "\n")
-		if err != nil {
-			return fmt.Errorf("failed to write to known hosts file")
-		}
-		return nil
-	} else if err != nil && !s.trustServerHost {
-		return err
+// Connected reports whether the server still answers on the connection. A
+// connection that does not answer within the connect timeout is closed.
+func (s *sshSession) Connected() bool {
+	timeout := s.opt.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
 	}
-	return nil
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := s.conn.client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-s.conn.done:
+		return false
+	case <-time.After(timeout):
+		s.conn.Close()
+		return false
+	}
+}
+
+// Reconnect replaces a lost connection and its SFTP subsystem.
+func (s *sshSession) Reconnect() error {
+	s.sftp.Close()
+	return s.open(s.conn)
 }
 
 func (s *sshSession) Exec(cmdOutput io.Writer, command string, args ...string) error {
-	session, err := s.client.NewSession()
+	session, err := s.conn.client.NewSession()
 	if err != nil {
 		return fmt.Errorf("failed to create session")
 	}
@@ -88,6 +178,11 @@ func (s *sshSession) Exec(cmdOutput io.Writer, command string, args ...string) e
 	if err != nil {
 		return fmt.Errorf("failed to create session")
 	}
+	if s.conn.forwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return fmt.Errorf("failed to request agent forwarding: %w", err)
+		}
+	}
 	done := make(chan bool, 2)
 	defer func() {
 		<-done
@@ -170,17 +265,140 @@ func (s *sshSession) sendfile(progress *progressCopy, src, dest string) error {
 		r = progress
 	}
 
-	dfile, err := s.sftp.Create(dest)
+	// written to a sibling that replaces dest once complete, a failed upload
+	// leaves dest as it was. A symbolic link is written through, the sibling
+	// replaces the file it points to.
+	target, err := s.uploadTarget(dest)
 	if err != nil {
 		return err
 	}
-	defer dfile.close()
+	replaced, err := s.sftp.Stat(target)
+	if err != nil {
+		replaced = nil
+	}
+	part := partName(target)
+	pflags := sshFxfRead | sshFxfWrite | sshFxfCreat | sshFxfTrunc
+	var offset int64
+	if s.transfer.Resume {
+		size := sfileStat.Size()
+		if replaced != nil && int64(replaced.size) == size {
+			// completed by the transfer resumed, unless dest is another
+			// file of the same size
+			local, err := fileHash(src)
+			if err != nil {
+				return err
+			}
+			if remote, err := s.remoteHash(target); err == nil && remote == local {
+				return nil
+			}
+		}
+		if partStat, err := s.sftp.Stat(part); err == nil {
+			if offset, err = s.remoteResumeOffset(sfile, size, part, int64(partStat.size)); err != nil {
+				return err
+			}
+		}
+		if offset > 0 {
+			pflags &^= sshFxfTrunc
+			if _, err := sfile.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress.SetOffset(offset)
+			}
+		}
+	}
+	h, err := s.localHash(sfile, offset)
+	if err != nil {
+		return err
+	}
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	dfile, err := s.sftp.OpenFile(part, pflags, unixPerm(s.transfer.Mode))
+	if errors.Is(err, os.ErrPermission) && offset == 0 {
+		// no sibling can be created in the directory, dest is written in
+		// place and a failed upload leaves it truncated
+		part = ""
+		dfile, err = s.sftp.OpenFile(target, sshFxfWrite|sshFxfCreat|sshFxfTrunc, unixPerm(s.transfer.Mode))
+	}
+	if err != nil {
+		return err
+	}
+	dfile.offset = uint64(offset)
+	err = s.upload(dfile, r, sfileStat, replaced)
+	if cerr := dfile.close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// before replacing dest, which a mismatch leaves as it was
+		if err = s.verify(h, dfile.path); err != nil {
+			err = fmt.Errorf("%s: %w", dest, err)
+		}
+	}
+	if part == "" {
+		return err
+	}
+	if err == nil {
+		err = s.sftp.Replace(part, target)
+	}
+	if err != nil {
+		s.sftp.RemoveFile(part)
+	}
+	return err
+}
+
+// uploadTarget returns the file an upload to dest writes: dest itself or,
+// when dest is a symbolic link, the file it points to.
+func (s *sshSession) uploadTarget(dest string) (string, error) {
+	for range maxSymlinks {
+		fi, err := s.sftp.Lstat(dest)
+		if err != nil || !fi.IsSymlink() {
+			return dest, nil
+		}
+		link, err := s.sftp.ReadLink(dest)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(link) {
+			link = path.Join(path.Dir(dest), link)
+		}
+		dest = link
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", dest)
+}
 
-	if _, err := dfile.readFrom(r, sfileStat.Size(), s.sftp.useConcurrency); err != nil {
+// upload writes r to dfile and sets its attributes. The file it replaces
+// keeps its permissions and owner unless they are set otherwise.
+func (s *sshSession) upload(dfile *file, r io.Reader, fi os.FileInfo, replaced *fileStat) error {
+	if _, err := dfile.readFrom(r, fi.Size()-int64(dfile.offset), s.sftp.useConcurrency); err != nil {
 		return err
 	}
 
-	return nil
+	// set once written, the umask and the writes change the attributes given
+	// at creation
+	attrs, err := s.uploadAttrs(fi, dfile.path, s.transfer.Mode)
+	if err != nil {
+		return err
+	}
+	if replaced != nil && attrs.flags&sshFileXferAttrPermissions == 0 {
+		attrs.flags |= sshFileXferAttrPermissions
+		attrs.perm = replaced.mode & 0o7777
+	}
+	if attrs.flags != 0 {
+		if err := dfile.setStat(attrs); err != nil {
+			return err
+		}
+	}
+	if replaced == nil || attrs.flags&sshFileXferAttrUIDGID != 0 {
+		return nil
+	}
+	// only root may give files away, the owner is kept where the server
+	// allows it
+	err = dfile.setStat(fileAttrs{flags: sshFileXferAttrUIDGID, uid: replaced.uid, gid: replaced.gid})
+	if errors.Is(err, os.ErrPermission) {
+		return nil
+	}
+	return err
 }
 
 func (s *sshSession) receivefile(progress *progressCopy, remoteSrc, dest string) error {
@@ -189,24 +407,89 @@ func (s *sshSession) receivefile(progress *progressCopy, remoteSrc, dest string)
 		return err
 	}
 	defer remoteFile.close()
-	localFile, err := os.Create(dest)
+	stat, _ := s.sftp.Stat(remoteSrc)
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	var offset int64
+	if s.transfer.Resume {
+		if offset, err = localResumeOffset(remoteFile, int64(stat.size), dest); err != nil {
+			return err
+		}
+		if offset == int64(stat.size) {
+			// completed by the transfer resumed, unless dest is another
+			// file of the same size
+			local, err := fileHash(dest)
+			if err != nil {
+				return err
+			}
+			if remote, err := s.remoteHash(remoteSrc); err != nil || remote != local {
+				offset = 0
+			}
+		}
+		if offset > 0 {
+			flag &^= os.O_TRUNC
+		}
+	}
+	localFile, err := os.OpenFile(dest, flag, 0666)
 	if err != nil {
 		return err
 	}
+	defer localFile.Close()
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	remoteFile.offset = uint64(offset)
 	var w io.Writer = localFile
-	stat, _ := s.sftp.Stat(remoteSrc)
 	if progress != nil {
 		progress.SetWriter(localFile)
 		progress.SetSize(int64(stat.size))
+		progress.SetOffset(offset)
 		w = progress
 	}
-	defer localFile.Close()
-	if _, err := remoteFile.writeTo(w, int64(stat.size), s.sftp.useConcurrency); err != nil {
+	h, err := s.localHash(localFile, offset)
+	if err != nil {
 		return err
 	}
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	if _, err := remoteFile.writeTo(w, int64(stat.size)-offset, s.sftp.useConcurrency); err != nil {
+		return err
+	}
+	if err := s.verify(h, remoteSrc); err != nil {
+		return fmt.Errorf("%s: %w", dest, err)
+	}
+	if s.transfer.Preserve {
+		return stat.setLocal(dest)
+	}
 	return nil
 }
 
+// remoteResumeOffset returns the offset an upload of src resumes from, given
+// the partial remote file it left.
+func (s *sshSession) remoteResumeOffset(src *os.File, size int64, partial string, partialSize int64) (int64, error) {
+	pfile, err := s.sftp.Open(partial)
+	if err != nil {
+		return 0, err
+	}
+	defer pfile.close()
+	return resumeOffset(src, size, pfile, partialSize)
+}
+
+// localResumeOffset returns the offset a download of src resumes from, given
+// the partial local file it left.
+func localResumeOffset(src *file, size int64, partial string) (int64, error) {
+	stat, err := os.Stat(partial)
+	if err != nil || !stat.Mode().IsRegular() {
+		return 0, nil
+	}
+	pfile, err := os.Open(partial)
+	if err != nil {
+		return 0, err
+	}
+	defer pfile.Close()
+	return resumeOffset(src, size, pfile, stat.Size())
+}
+
 func sftp(output io.Writer, src, dest string, sftpfunc sftpFunc, isReader bool, isConcurrency bool) error {
 	var ch chan networkBytes
 	var progress *progressCopy
@@ -251,6 +534,10 @@ func (s *sshSession) SendFile(progress io.Writer, src string, dest string) error
 		panic(fmt.Sprintf("SendFile: %s is directory, src is supposed to be file not directory", src))
 	}
 
+	if _, err := s.uploadOwner(); err != nil {
+		return err
+	}
+
 	dest, err = remoterealpath(s, dest)
 	if err != nil {
 		return err
@@ -279,9 +566,35 @@ func (s *sshSession) SendDir(progress io.Writer, src string, dest string) error
 
 	dest = path.Join(dest, filepath.Base(src))
 
-	if err = s.sftp.Mkdir(dest); err != nil {
+	if _, err := s.uploadOwner(); err != nil {
 		return err
 	}
+	w, err := s.newDirWalk(os.ReadFile, filepath.Join(src, IgnoreFile))
+	if err != nil {
+		return err
+	}
+	return s.sendDir(progress, src, dest, w)
+}
+
+// sendDir uploads the directory src as dest.
+func (s *sshSession) sendDir(progress io.Writer, src, dest string, w dirWalk) error {
+	real, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	if w.ancestors[real] {
+		skipped(progress, src, "link to a parent directory")
+		return nil
+	}
+	w.ancestors[real] = true
+	defer delete(w.ancestors, real)
+
+	if err = s.sftp.Mkdir(dest); err != nil {
+		// a resumed transfer continues in the directories it created
+		if isDir, _ := s.sftp.IsDir(dest); !s.transfer.Resume || !isDir {
+			return err
+		}
+	}
 	files, err := os.ReadDir(src)
 	if err != nil {
 		return err
@@ -291,24 +604,75 @@ func (s *sshSession) SendDir(progress io.Writer, src string, dest string) error
 		if file.Name() == "." || file.Name() == ".." {
 			continue
 		}
-		if file.Type().IsDir() {
-			s.SendDir(progress, path.Join(src, file.Name()), path.Join(dest, file.Name()))
-		} else if file.Type().IsRegular() {
-			start := time.Now()
-			srcF := path.Join(src, file.Name())
-			if err := s.sendfile(nil, srcF, path.Join(dest, file.Name())); err != nil {
+		srcF := filepath.Join(src, file.Name())
+		destF := path.Join(dest, file.Name())
+		typ := file.Type()
+		if typ&os.ModeSymlink != 0 && s.transfer.FollowLinks {
+			stat, err := os.Stat(srcF)
+			if err != nil {
+				skipped(progress, srcF, "broken link")
+				continue
+			}
+			typ = stat.Mode().Type()
+		}
+		if w.excluded(file.Name(), typ.IsDir()) {
+			continue
+		}
+		switch {
+		case typ&os.ModeSymlink != 0:
+			if err := s.sendLink(progress, srcF, destF); err != nil {
 				return err
 			}
-			if progress != nil {
-				seconds := time.Since(start).Seconds()
-				if seconds > 120 {
-					progress.Write([]byte(fmt.Sprintf("%s - %0.2f min\n", srcF, seconds/60)))
-				} else {
-					progress.Write([]byte(fmt.Sprintf("%s - %0.2f sec\n", srcF, seconds)))
-				}
+		case typ.IsDir():
+			if err := s.sendDir(progress, srcF, destF, w.child(file.Name())); err != nil {
+				return err
 			}
+		case typ.IsRegular():
+			start := time.Now()
+			if err := s.sendfile(nil, srcF, destF); err != nil {
+				return err
+			}
+			transferred(progress, srcF, start)
+		default:
+			skipped(progress, srcF, "not a regular file, directory or link")
 		}
 	}
+	// after the files, which change the modification time of dest and may
+	// not be writable with DirMode
+	return s.setDirAttrs(src, dest)
+}
+
+// setDirAttrs sets the attributes of the remote directory dest uploaded from
+// src.
+func (s *sshSession) setDirAttrs(src, dest string) error {
+	srcStat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	attrs, err := s.uploadAttrs(srcStat, dest, s.transfer.DirMode)
+	if err != nil || attrs.flags == 0 {
+		return err
+	}
+	return s.sftp.SetStat(dest, attrs)
+}
+
+// sendLink recreates the symbolic link src as dest, replacing a file or link
+// already there.
+func (s *sshSession) sendLink(progress io.Writer, src, dest string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if stat, err := s.sftp.Lstat(dest); err == nil && !stat.IsDir() {
+		if err := s.sftp.RemoveFile(dest); err != nil {
+			return err
+		}
+	}
+	target = filepath.ToSlash(target)
+	if err := s.sftp.Symlink(target, dest); err != nil {
+		return err
+	}
+	linked(progress, src, target)
 	return nil
 }
 
@@ -331,7 +695,16 @@ func (s *sshSession) ReceiveRemoteFile(progress io.Writer, remoteSrc string, des
 	if _, ok := err.(*os.PathError); !ok {
 		if stat.IsDir() {
 			dest = path.Join(dest, filepath.Base(remoteSrc))
-		} else {
+		} else if !s.transfer.Resume {
+			return fmt.Errorf("file already exists")
+		}
+	}
+	if s.transfer.Resume {
+		// a resumed transfer continues in the file it left, another file of
+		// the same name is not overwritten
+		if ok, err := s.resumable(remoteSrc, dest); err != nil {
+			return err
+		} else if !ok {
 			return fmt.Errorf("file already exists")
 		}
 	}
@@ -339,6 +712,35 @@ func (s *sshSession) ReceiveRemoteFile(progress io.Writer, remoteSrc string, des
 	return sftp(progress, remoteSrc, dest, s.receivefile, false, s.sftp.useConcurrency)
 }
 
+// resumable reports whether a download of remoteSrc may continue in dest:
+// dest does not exist, is empty or starts as remoteSrc does, as left by an
+// interrupted download.
+func (s *sshSession) resumable(remoteSrc, dest string) (bool, error) {
+	stat, err := os.Stat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !stat.Mode().IsRegular() {
+		return false, nil
+	}
+	if stat.Size() == 0 {
+		return true, nil
+	}
+	remoteFile, err := s.sftp.Open(remoteSrc)
+	if err != nil {
+		return false, err
+	}
+	defer remoteFile.close()
+	remoteStat, err := s.sftp.Stat(remoteSrc)
+	if err != nil {
+		return false, err
+	}
+	offset, err := localResumeOffset(remoteFile, int64(remoteStat.size), dest)
+	return offset > 0, err
+}
+
 func (s *sshSession) ReceiveRemoteDir(progress io.Writer, remoteDir string, destDir string) error {
 	remoteDir, err := remoterealpath(s, remoteDir)
 	if err != nil {
@@ -350,37 +752,97 @@ func (s *sshSession) ReceiveRemoteDir(progress io.Writer, remoteDir string, dest
 		return err
 	}
 
-	destDir = path.Join(destDir, filepath.Base(remoteDir))
+	destDir = filepath.Join(destDir, path.Base(remoteDir))
+
+	w, err := s.newDirWalk(s.readRemoteFile, path.Join(remoteDir, IgnoreFile))
+	if err != nil {
+		return err
+	}
+	return s.receiveDir(progress, remoteDir, destDir, w)
+}
+
+// receiveDir downloads the remote directory remoteDir as destDir.
+func (s *sshSession) receiveDir(progress io.Writer, remoteDir, destDir string, w dirWalk) error {
+	real, err := s.sftp.RealPath(remoteDir)
+	if err != nil {
+		return err
+	}
+	if w.ancestors[real] {
+		skipped(progress, remoteDir, "link to a parent directory")
+		return nil
+	}
+	w.ancestors[real] = true
+	defer delete(w.ancestors, real)
 
 	remoteDirFiles, err := s.sftp.ReadDir(remoteDir)
 	if err != nil {
 		return err
 	}
 	if err := os.Mkdir(destDir, 0755); err != nil {
-		return err
+		// a resumed transfer continues in the directories it created
+		if stat, serr := os.Stat(destDir); !s.transfer.Resume || serr != nil || !stat.IsDir() {
+			return err
+		}
 	}
 
 	for _, file := range remoteDirFiles {
-		if file.stat.IsDir() {
-			if err := s.ReceiveRemoteDir(progress, path.Join(remoteDir, file.name), destDir); err != nil {
+		rsrc := path.Join(remoteDir, file.name)
+		dest := filepath.Join(destDir, file.name)
+		stat := file.stat
+		if stat.IsSymlink() && s.transfer.FollowLinks {
+			if stat, err = s.sftp.Stat(rsrc); err != nil {
+				skipped(progress, rsrc, "broken link")
+				continue
+			}
+		}
+		if w.excluded(file.name, stat.IsDir()) {
+			continue
+		}
+		switch {
+		case stat.IsSymlink():
+			if err := s.receiveLink(progress, rsrc, dest); err != nil {
 				return err
 			}
-		} else if file.stat.IsRegular() {
-			start := time.Now()
-			rsrc := path.Join(remoteDir, file.name)
-			if err := s.receivefile(nil, rsrc, path.Join(destDir, file.name)); err != nil {
+		case stat.IsDir():
+			if err := s.receiveDir(progress, rsrc, dest, w.child(file.name)); err != nil {
 				return err
 			}
-			if progress != nil {
-				seconds := time.Since(start).Seconds()
-				if seconds > 120 {
-					progress.Write([]byte(fmt.Sprintf("%s - %0.2f min\n", rsrc, seconds/60)))
-				} else {
-					progress.Write([]byte(fmt.Sprintf("%s - %0.2f sec\n", rsrc, seconds)))
-				}
+		case stat.IsRegular():
+			start := time.Now()
+			if err := s.receivefile(nil, rsrc, dest); err != nil {
+				return err
 			}
+			transferred(progress, rsrc, start)
+		default:
+			skipped(progress, rsrc, "not a regular file, directory or link")
+		}
+	}
+	if s.transfer.Preserve {
+		stat, err := s.sftp.Stat(remoteDir)
+		if err != nil {
+			return err
+		}
+		return stat.setLocal(destDir)
+	}
+	return nil
+}
+
+// receiveLink recreates the remote symbolic link remoteSrc as dest, replacing
+// a file or link already there.
+func (s *sshSession) receiveLink(progress io.Writer, remoteSrc, dest string) error {
+	target, err := s.sftp.ReadLink(remoteSrc)
+	if err != nil {
+		return err
+	}
+	if stat, err := os.Lstat(dest); err == nil && !stat.IsDir() {
+		if err := os.Remove(dest); err != nil {
+			return err
 		}
 	}
+	if err := os.Symlink(filepath.FromSlash(target), dest); err != nil {
+		return err
+	}
+	linked(progress, remoteSrc, target)
 	return nil
 }
 
@@ -424,49 +886,274 @@ func (s *sshSession) RemoveAll(srcdir string) error {
 }
 
 func (s *sshSession) SetSftpConcurrency(concurrency bool) {
+	s.opt.SftpConcurrency = concurrency
 	s.sftp.useConcurrency = concurrency
 }
 
+func Connect(opt Options) (SshSession, error) {
+	return newSession(ownConn(dial), opt)
+}
+
+// ConnectWithPassword logs in to the server with nothing but a password or
+// keyboard-interactive answers. Jump hosts authenticate as usual.
 func ConnectWithPassword(opt Options) (SshSession, error) {
-	if knownHostKeyCallback == nil {
-		return nil, fmt.Errorf("unable to read known hosts file")
+	return newSession(ownConn(func(o Options) (*sshConn, error) {
+		agentConn := dialAgent()
+		if agentConn != nil {
+			defer agentConn.Close()
+		}
+		hop := 0
+		return connect(o, func(h Options) ([]ssh.AuthMethod, error) {
+			hop++
+			if hop <= len(o.Jump) {
+				return authMethods(h, agentConn)
+			}
+			password := passwordProvider(h)
+			return append(passwordAuth(h, password), keyboardInteractiveAuth(h, password)...), nil
+		})
+	}), opt)
+}
+
+// dial connects with every authentication method and sets up agent
+// forwarding when requested.
+func dial(opt Options) (*sshConn, error) {
+	agentConn := dialAgent()
+	if agentConn != nil {
+		defer agentConn.Close()
 	}
+	conn, err := connect(opt, func(o Options) ([]ssh.AuthMethod, error) {
+		return authMethods(o, agentConn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opt.ForwardAgent {
+		if err := forwardAgent(conn.client); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.forwardAgent = true
+	}
+	return conn, nil
+}
+
+func (o Options) address() string {
+	port := o.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(o.Server, strconv.Itoa(port))
+}
 
-	session := &sshSession{}
-	session.sftp = nil
-	session.trustServerHost = opt.TrustServerHost
+const (
+	DefaultConnectTimeout = 30 * time.Second
+	DefaultKeepAlive      = 30 * time.Second
+	// keepAliveCountMax unanswered keepalives close the connection
+	keepAliveCountMax = 3
+)
+
+// dialClient opens an SSH connection to opt, tunneled through via when it
+// is not nil and through the proxy otherwise. The timeout covers the TCP
+// connection and the handshake.
+func dialClient(opt Options, auth []ssh.AuthMethod, via *ssh.Client) (*ssh.Client, error) {
+	timeout := opt.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	hostKey := newHostKeyChecker(opt)
 	config := &ssh.ClientConfig{
-		User: opt.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(opt.Password),
-		},
-		HostKeyCallback: session.serverHostKey,
+		User:              opt.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKey.serverHostKey,
+		HostKeyAlgorithms: hostKey.hostKeyAlgorithms(opt.address()),
+	}
+	conn, err := dialConn(opt, via, timeout)
+	if err != nil {
+		return nil, err
 	}
-	client, err := ssh.Dial("tcp", opt.Server+":"+strconv.Itoa(opt.Port), config)
+	c, chans, reqs, err := handshake(conn, opt, config, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect to ssh server %s: %w", opt.Server, err)
 	}
-	session.client = client
-	sftp, err := NewSFTPClient(client, opt.SftpConcurrency)
+	client := ssh.NewClient(c, chans, reqs)
+	keepAlive := opt.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	if keepAlive > 0 {
+		go sendKeepAlives(client, keepAlive)
+	}
+	return client, nil
+}
+
+// dialConn opens the TCP connection to opt, tunneled through via when it is
+// not nil and through the proxy otherwise.
+func dialConn(opt Options, via *ssh.Client, timeout time.Duration) (net.Conn, error) {
+	if via != nil {
+		conn, err := via.Dial("tcp", opt.address())
+		if err != nil {
+			return nil, fmt.Errorf("cannot reach ssh server %s through jump host: %w", opt.Server, err)
+		}
+		return conn, nil
+	}
+	var conn net.Conn
+	var err error
+	proxy := opt.Proxy
+	if proxy == "" {
+		proxy = proxyFromEnvironment(opt.Server)
+	}
+	if proxy != "" && proxy != "none" {
+		conn, err = dialProxy(proxy, opt.address(), timeout)
+	} else {
+		conn, err = net.DialTimeout("tcp", opt.address(), timeout)
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot connect to ssh server %s: %w", opt.Server, err)
+	}
+	return conn, nil
+}
+
+// handshake runs the SSH handshake with opt on conn, which is closed when it
+// fails or does not finish within timeout.
+func handshake(conn net.Conn, opt Options, config *ssh.ClientConfig, timeout time.Duration) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	// channels of a jump host do not support deadlines
+	timer := time.AfterFunc(timeout, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, opt.address(), config)
+	if !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		err = fmt.Errorf("handshake timed out after %s", timeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return c, chans, reqs, nil
+}
+
+// sendKeepAlives sends keepalive@openssh.com every interval and closes the
+// client once the server stopped answering, so that a connection dropped by
+// a NAT is noticed instead of hanging.
+func sendKeepAlives(client *ssh.Client, interval time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	reply := make(chan error, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-closed:
+			return
+		case err := <-reply:
+			if err != nil {
+				client.Close()
+				return
+			}
+			pending, missed = false, 0
+		case <-ticker.C:
+			if pending {
+				if missed++; missed >= keepAliveCountMax {
+					client.Close()
+					return
+				}
+				continue
+			}
+			pending = true
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+		}
+	}
+}
+
+func connect(opt Options, auth func(Options) ([]ssh.AuthMethod, error)) (*sshConn, error) {
+	addSecrets(opt)
+	conn := &sshConn{done: make(chan struct{})}
+	var via *ssh.Client
+	hops := append(append([]Options{}, opt.Jump...), opt)
+	for _, hop := range hops {
+		hopAuth, err := auth(hop)
+		if err == nil {
+			via, err = dialClient(hop, hopAuth, via)
+		}
+		if err != nil {
+			for i := len(conn.jumps) - 1; i >= 0; i-- {
+				conn.jumps[i].Close()
+			}
+			return nil, err
+		}
+		conn.jumps = append(conn.jumps, via)
 	}
-	session.sftp = sftp
-	return session, nil
+	conn.client = via
+	conn.jumps = conn.jumps[:len(conn.jumps)-1]
+	go func() {
+		conn.client.Wait()
+		close(conn.done)
+	}()
+	return conn, nil
 }
 
 type Options struct {
-	Server          string
-	Port            int
-	User            string
-	Password        string
-	TrustServerHost bool
-	SftpConcurrency bool
+	Server           string
+	Port             int
+	User             string
+	Password         string
+	PasswordProvider credential.Provider // asked when Password is empty
+	KeyFile          string
+	Passphrase       string
+	Answers          map[string]string // keyboard-interactive answers keyed by prompt
+	Interactive      bool              // prompt on the terminal for a missing password or passphrase
+	ForwardAgent     bool
+	HostKeyPolicy    HostKeyPolicy
+	KnownHostsFile   string // default ~/.ssh/known_hosts
+	HashKnownHosts   bool
+	SftpConcurrency  bool
+	ConnectTimeout   time.Duration // default 30s
+	KeepAlive        time.Duration // keepalive interval, default 30s, negative disables
+	Proxy            string        // socks5, socks5h, http or https URL, "none" ignores ALL_PROXY and HTTPS_PROXY
+	Jump             []Options     // jump hosts, connected in order before Server
 }
 
-func init() {
-	if _, err := os.Stat(knownhostFile); os.IsNotExist(err) {
-		os.Create(knownhostFile)
+// ParseDestination splits a [user@]host[:port] destination as used by
+// --jump and ProxyJump. IPv6 addresses with a port must be enclosed in
+// brackets. A missing port is returned as 0.
+func ParseDestination(dest string) (user string, host string, port int, err error) {
+	dest = strings.TrimPrefix(dest, "ssh://")
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		user = dest[:i]
+		dest = dest[i+1:]
+	}
+	host = dest
+	p := ""
+	if strings.HasPrefix(dest, "[") {
+		end := strings.Index(dest, "]")
+		if end < 0 {
+			return "", "", 0, fmt.Errorf("missing ']' in destination: %s", dest)
+		}
+		host = dest[1:end]
+		if rest := dest[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return "", "", 0, fmt.Errorf("invalid destination: %s", dest)
+			}
+			p = rest[1:]
+		}
+	} else if strings.Count(dest, ":") == 1 {
+		i := strings.Index(dest, ":")
+		host, p = dest[:i], dest[i+1:]
+	}
+	if p != "" {
+		if port, err = strconv.Atoi(p); err != nil || port <= 0 || port > 65535 {
+			return "", "", 0, fmt.Errorf("invalid port in destination: %s", dest)
+		}
+	}
+	if host == "" {
+		return "", "", 0, fmt.Errorf("missing host in destination: %s", dest)
 	}
-	knownHostKeyCallback, _ = knownhosts.New(knownhostFile)
+	return user, host, port, nil
 }
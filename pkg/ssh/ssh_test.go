@@ -0,0 +1,173 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSendFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		link   string // dest links to it when set
+		denied bool   // no file can be created next to the file written
+	}{
+		{"regular file", "", false},
+		{"symlink", "target", false},
+		{"symlink to another directory", "../other/target", false},
+		{"symlink chain", "link2", false},
+		{"read-only directory", "", true},
+		{"symlink into a read-only directory", "../other/target", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir, other := filepath.Join(root, "dir"), filepath.Join(root, "other")
+			for _, d := range []string{dir, other} {
+				if err := os.Mkdir(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			src := filepath.Join(root, "src")
+			dest := filepath.Join(dir, "dest")
+			if err := os.WriteFile(src, []byte("new content"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			written := dest
+			if tt.link != "" {
+				written = filepath.Join(dir, tt.link)
+				if tt.link == "link2" {
+					os.Symlink("target", written)
+					written = filepath.Join(dir, "target")
+				}
+				os.Symlink(tt.link, dest)
+			}
+			if err := os.WriteFile(written, []byte("old"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			var denied []string
+			if tt.denied {
+				denied = []string{filepath.Dir(written)}
+			}
+
+			s := &sshSession{sftp: sftpServer(t, denied...)}
+			if err := s.sendfile(nil, src, dest); err != nil {
+				t.Fatal(err)
+			}
+
+			if b, err := os.ReadFile(written); err != nil || string(b) != "new content" {
+				t.Errorf("%s holds %q, %v", written, b, err)
+			}
+			if fi, err := os.Stat(written); err != nil || fi.Mode().Perm() != 0o600 {
+				t.Errorf("%s lost its permissions: %v, %v", written, fi.Mode(), err)
+			}
+			if fi, err := os.Lstat(dest); err != nil || (fi.Mode()&os.ModeSymlink != 0) != (tt.link != "") {
+				t.Errorf("dest replaced: %v, %v", fi.Mode(), err)
+			}
+			for _, d := range []string{dir, other} {
+				if part, _ := filepath.Glob(filepath.Join(d, "*.mdeploy-part")); len(part) > 0 {
+					t.Errorf("part file left: %v", part)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveRemoteFileResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 8192)
+	tests := []struct {
+		name  string
+		local []byte // nil when there is no local file
+		inDir bool   // the download is asked into the directory holding it
+		want  string // error, empty when downloaded
+	}{
+		{"no local file", nil, false, ""},
+		{"empty local file", []byte{}, false, ""},
+		{"partial download", content[:40000], false, ""},
+		{"partial download in directory", content[:40000], true, ""},
+		{"other file", []byte("my notes"), false, "already exists"},
+		{"other file in directory", []byte("my notes"), true, "already exists"},
+		{"other file of a larger size", append([]byte("x"), content...), false, "already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			remote := filepath.Join(dir, "remote")
+			if err := os.WriteFile(remote, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			local := filepath.Join(dir, "local", "remote")
+			if err := os.Mkdir(filepath.Dir(local), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.local != nil {
+				if err := os.WriteFile(local, tt.local, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dest := local
+			if tt.inDir {
+				dest = filepath.Dir(local)
+			}
+
+			s := &sshSession{sftp: sftpServer(t), transfer: TransferOptions{Resume: true}}
+			err := s.ReceiveRemoteFile(nil, remote, dest)
+			b, _ := os.ReadFile(local)
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("got error %v, want %q", err, tt.want)
+				}
+				if !bytes.Equal(b, tt.local) {
+					t.Errorf("local file overwritten")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Errorf("got %d bytes, want the %d of the remote file", len(b), len(content))
+			}
+		})
+	}
+}
+
+func TestParseDestination(t *testing.T) {
+	tests := []struct {
+		dest string
+		user string
+		host string
+		port int
+		ok   bool
+	}{
+		{"web1", "", "web1", 0, true},
+		{"deploy@web1", "deploy", "web1", 0, true},
+		{"deploy@web1:2222", "deploy", "web1", 2222, true},
+		{"ssh://deploy@web1:2222", "deploy", "web1", 2222, true},
+		{"first.last@corp@web1", "first.last@corp", "web1", 0, true},
+		{"::1", "", "::1", 0, true},
+		{"deploy@[::1]", "deploy", "::1", 0, true},
+		{"deploy@[::1]:2222", "deploy", "::1", 2222, true},
+		{"[fe80::1%eth0]:22", "", "fe80::1%eth0", 22, true},
+		{"deploy@", "", "", 0, false},
+		{"web1:", "", "web1", 0, true},
+		{"web1:ssh", "", "", 0, false},
+		{"web1:0", "", "", 0, false},
+		{"web1:65536", "", "", 0, false},
+		{"[::1", "", "", 0, false},
+		{"[::1]2222", "", "", 0, false},
+		{"[]:22", "", "", 0, false},
+	}
+	for _, tt := range tests {
+		user, host, port, err := ParseDestination(tt.dest)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseDestination(%q): got error %v", tt.dest, err)
+			continue
+		}
+		if tt.ok && (user != tt.user || host != tt.host || port != tt.port) {
+			t.Errorf("ParseDestination(%q) = %q, %q, %d, want %q, %q, %d", tt.dest, user, host, port, tt.user, tt.host, tt.port)
+		}
+	}
+}
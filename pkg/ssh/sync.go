@@ -0,0 +1,391 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// SyncToRemote makes the remote directory dest a copy of the local directory
+// src, copying only the files that changed. A file changed when its size or
+// modification time differ, or its hash with Checksum.
+func (s *sshSession) SyncToRemote(progress io.Writer, src string, dest string) error {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if stat, err := os.Stat(src); err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+
+	real, err := remoterealpath(s, dest)
+	if err != nil {
+		// dest is created by the sync, its parent must exist
+		parent, perr := remoterealpath(s, path.Dir(dest))
+		if perr != nil {
+			return err
+		}
+		real = path.Join(parent, path.Base(dest))
+	}
+
+	if _, err := s.uploadOwner(); err != nil {
+		return err
+	}
+	w, err := s.newDirWalk(os.ReadFile, filepath.Join(src, IgnoreFile))
+	if err != nil {
+		return err
+	}
+	defer s.keepTimes()()
+	return s.syncToRemote(progress, src, real, w)
+}
+
+// SyncFromRemote makes the local directory dest a copy of the remote
+// directory remoteSrc, as SyncToRemote.
+func (s *sshSession) SyncFromRemote(progress io.Writer, remoteSrc string, dest string) error {
+	remoteSrc, err := remoterealpath(s, remoteSrc)
+	if err != nil {
+		return err
+	}
+	if stat, err := s.sftp.Stat(remoteSrc); err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", remoteSrc)
+	}
+
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	w, err := s.newDirWalk(s.readRemoteFile, path.Join(remoteSrc, IgnoreFile))
+	if err != nil {
+		return err
+	}
+	defer s.keepTimes()()
+	return s.syncFromRemote(progress, remoteSrc, dest, w)
+}
+
+// keepTimes preserves the times of the files a sync copies, which the next
+// sync compares, until the returned function restores the options.
+func (s *sshSession) keepTimes() func() {
+	preserve := s.transfer.Preserve
+	s.transfer.Preserve = true
+	return func() {
+		s.transfer.Preserve = preserve
+	}
+}
+
+// syncToRemote syncs the directory src to dest. The destination files
+// excluded by the walk are neither copied nor deleted.
+func (s *sshSession) syncToRemote(progress io.Writer, src, dest string, w dirWalk) error {
+	real, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	if w.ancestors[real] {
+		skipped(progress, src, "link to a parent directory")
+		return nil
+	}
+	w.ancestors[real] = true
+	defer delete(w.ancestors, real)
+
+	entries, err := s.sftp.ReadDir(dest)
+	if errors.Is(err, os.ErrNotExist) {
+		err = s.sftp.Mkdir(dest)
+	}
+	if err != nil {
+		return err
+	}
+	remote := make(map[string]*fileStat, len(entries))
+	for _, e := range entries {
+		remote[e.name] = e.stat
+	}
+
+	files, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		srcF := filepath.Join(src, file.Name())
+		destF := path.Join(dest, file.Name())
+		existing := remote[file.Name()]
+		delete(remote, file.Name())
+		typ := file.Type()
+		if typ&os.ModeSymlink != 0 && s.transfer.FollowLinks {
+			stat, err := os.Stat(srcF)
+			if err != nil {
+				skipped(progress, srcF, "broken link")
+				continue
+			}
+			typ = stat.Mode().Type()
+		}
+		if w.excluded(file.Name(), typ.IsDir()) {
+			continue
+		}
+		switch {
+		case typ&os.ModeSymlink != 0:
+			if err := s.syncLinkToRemote(progress, srcF, destF, existing); err != nil {
+				return err
+			}
+		case typ.IsDir():
+			if existing != nil && !existing.IsDir() {
+				if err := s.sftp.RemoveFile(destF); err != nil {
+					return err
+				}
+			}
+			if err := s.syncToRemote(progress, srcF, destF, w.child(file.Name())); err != nil {
+				return err
+			}
+		case typ.IsRegular():
+			if err := s.syncFileToRemote(progress, srcF, destF, existing); err != nil {
+				return err
+			}
+		default:
+			skipped(progress, srcF, "not a regular file, directory or link")
+		}
+	}
+	if s.transfer.Delete {
+		for _, name := range slices.Sorted(maps.Keys(remote)) {
+			if w.excluded(name, remote[name].IsDir()) {
+				continue
+			}
+			destF := path.Join(dest, name)
+			if err := s.removeRemote(destF, remote[name]); err != nil {
+				return err
+			}
+			deleted(progress, destF)
+		}
+	}
+	return s.setDirAttrs(src, dest)
+}
+
+func (s *sshSession) syncFileToRemote(progress io.Writer, src, dest string, existing *fileStat) error {
+	if existing != nil && existing.IsDir() {
+		if err := s.removeRemoteDir(dest); err != nil {
+			return err
+		}
+	} else if existing != nil && existing.IsRegular() {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if same, err := s.unchanged(fi, src, existing, dest); err != nil || same {
+			return err
+		}
+	}
+	start := time.Now()
+	if err := s.sendfile(nil, src, dest); err != nil {
+		return err
+	}
+	transferred(progress, src, start)
+	return nil
+}
+
+func (s *sshSession) syncLinkToRemote(progress io.Writer, src, dest string, existing *fileStat) error {
+	if existing != nil && existing.IsSymlink() {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if current, err := s.sftp.ReadLink(dest); err == nil && current == filepath.ToSlash(target) {
+			return nil
+		}
+	} else if existing != nil && existing.IsDir() {
+		if err := s.removeRemoteDir(dest); err != nil {
+			return err
+		}
+	}
+	return s.sendLink(progress, src, dest)
+}
+
+// removeRemote removes the remote file or directory dest, which a sync with
+// Delete finds missing from the source.
+func (s *sshSession) removeRemote(dest string, stat *fileStat) error {
+	if stat.IsDir() {
+		return s.RemoveAll(dest)
+	}
+	return s.sftp.RemoveFile(dest)
+}
+
+// removeRemoteDir removes the remote directory dest, in the way of a file of
+// the source.
+func (s *sshSession) removeRemoteDir(dest string) error {
+	if !s.transfer.Delete {
+		return dirInTheWay(dest)
+	}
+	return s.RemoveAll(dest)
+}
+
+// syncFromRemote syncs the remote directory remoteDir to destDir, as
+// syncToRemote.
+func (s *sshSession) syncFromRemote(progress io.Writer, remoteDir, destDir string, w dirWalk) error {
+	real, err := s.sftp.RealPath(remoteDir)
+	if err != nil {
+		return err
+	}
+	if w.ancestors[real] {
+		skipped(progress, remoteDir, "link to a parent directory")
+		return nil
+	}
+	w.ancestors[real] = true
+	defer delete(w.ancestors, real)
+
+	remoteDirFiles, err := s.sftp.ReadDir(remoteDir)
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(destDir)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Mkdir(destDir, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	local := make(map[string]os.FileInfo, len(files))
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		local[file.Name()] = info
+	}
+
+	for _, file := range remoteDirFiles {
+		rsrc := path.Join(remoteDir, file.name)
+		dest := filepath.Join(destDir, file.name)
+		existing := local[file.name]
+		delete(local, file.name)
+		stat := file.stat
+		if stat.IsSymlink() && s.transfer.FollowLinks {
+			if stat, err = s.sftp.Stat(rsrc); err != nil {
+				skipped(progress, rsrc, "broken link")
+				continue
+			}
+		}
+		if w.excluded(file.name, stat.IsDir()) {
+			continue
+		}
+		switch {
+		case stat.IsSymlink():
+			if err := s.syncLinkFromRemote(progress, rsrc, dest, existing); err != nil {
+				return err
+			}
+		case stat.IsDir():
+			if existing != nil && !existing.IsDir() {
+				if err := os.Remove(dest); err != nil {
+					return err
+				}
+			}
+			if err := s.syncFromRemote(progress, rsrc, dest, w.child(file.name)); err != nil {
+				return err
+			}
+		case stat.IsRegular():
+			if err := s.syncFileFromRemote(progress, rsrc, dest, stat, existing); err != nil {
+				return err
+			}
+		default:
+			skipped(progress, rsrc, "not a regular file, directory or link")
+		}
+	}
+	if s.transfer.Delete {
+		for _, name := range slices.Sorted(maps.Keys(local)) {
+			if w.excluded(name, local[name].IsDir()) {
+				continue
+			}
+			dest := filepath.Join(destDir, name)
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+			deleted(progress, dest)
+		}
+	}
+	if s.transfer.Preserve {
+		stat, err := s.sftp.Stat(remoteDir)
+		if err != nil {
+			return err
+		}
+		return stat.setLocal(destDir)
+	}
+	return nil
+}
+
+func (s *sshSession) syncFileFromRemote(progress io.Writer, remoteSrc, dest string, stat *fileStat, existing os.FileInfo) error {
+	if existing != nil && existing.IsDir() {
+		if err := s.removeLocalDir(dest); err != nil {
+			return err
+		}
+	} else if existing != nil && existing.Mode()&os.ModeSymlink != 0 {
+		// replaced rather than written through
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	} else if existing != nil && existing.Mode().IsRegular() {
+		if same, err := s.unchanged(existing, dest, stat, remoteSrc); err != nil || same {
+			return err
+		}
+	}
+	start := time.Now()
+	if err := s.receivefile(nil, remoteSrc, dest); err != nil {
+		return err
+	}
+	transferred(progress, remoteSrc, start)
+	return nil
+}
+
+func (s *sshSession) syncLinkFromRemote(progress io.Writer, remoteSrc, dest string, existing os.FileInfo) error {
+	if existing != nil && existing.Mode()&os.ModeSymlink != 0 {
+		target, err := s.sftp.ReadLink(remoteSrc)
+		if err != nil {
+			return err
+		}
+		if current, err := os.Readlink(dest); err == nil && filepath.ToSlash(current) == target {
+			return nil
+		}
+	} else if existing != nil && existing.IsDir() {
+		if err := s.removeLocalDir(dest); err != nil {
+			return err
+		}
+	}
+	return s.receiveLink(progress, remoteSrc, dest)
+}
+
+// removeLocalDir removes the local directory dest, in the way of a file of
+// the source.
+func (s *sshSession) removeLocalDir(dest string) error {
+	if !s.transfer.Delete {
+		return dirInTheWay(dest)
+	}
+	return os.RemoveAll(dest)
+}
+
+func dirInTheWay(name string) error {
+	return fmt.Errorf("%s is a directory, only a sync with delete replaces it", name)
+}
+
+// unchanged reports whether a sync skips the local file, given its remote
+// counterpart of the same type.
+func (s *sshSession) unchanged(local os.FileInfo, localPath string, remote *fileStat, remotePath string) (bool, error) {
+	if int64(remote.size) != local.Size() {
+		return false, nil
+	}
+	if !s.transfer.Checksum {
+		return int64(remote.mtime) == local.ModTime().Unix(), nil
+	}
+	localSum, err := fileHash(localPath)
+	if err != nil {
+		return false, err
+	}
+	remoteSum, err := s.remoteHash(remotePath)
+	if err != nil {
+		return false, err
+	}
+	return localSum == remoteSum, nil
+}
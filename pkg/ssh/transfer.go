@@ -0,0 +1,349 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/san-gg/mdeploy/pkg/ignore"
+)
+
+// TransferOptions change how SendFile, SendDir, ReceiveRemoteFile and
+// ReceiveRemoteDir copy files.
+type TransferOptions struct {
+	Preserve bool        // copy permission bits and access and modification times
+	Mode     os.FileMode // permission bits of uploaded files, 0 keeps the default
+	DirMode  os.FileMode // permission bits of directories created by SendDir, 0 keeps the default
+	Owner    string      // user name or uid of uploaded files and directories
+	Group    string      // group name or gid of uploaded files and directories
+	// FollowLinks copies what the symbolic links in a directory point to
+	// instead of recreating the links.
+	FollowLinks bool
+	// Resume continues an interrupted transfer from the partial file it
+	// left, when its end matches the source.
+	Resume bool
+	// Verify is the hash compared between the local and remote files once
+	// transferred, VerifySHA256 or empty.
+	Verify string
+	// Checksum makes a sync compare the hashes of files of the same size
+	// instead of their modification times.
+	Checksum bool
+	// Delete makes a sync remove the destination files missing from the
+	// source.
+	Delete bool
+	// Exclude holds the gitignore patterns of the files left out of directory
+	// transfers, along with the IgnoreFile of the source directory.
+	Exclude []string
+	// Include holds patterns of files transferred even when excluded.
+	Include []string
+}
+
+// IgnoreFile holds the gitignore patterns of the files left out of the
+// transfers of the directory it is in. It is not transferred itself.
+const IgnoreFile = ".mdeployignore"
+
+// dirWalk is the state of a directory transfer, passed down to its
+// subdirectories.
+type dirWalk struct {
+	rel       string          // slash separated path of the directory in the transfer
+	ancestors map[string]bool // real paths of the directories above, a followed link must not lead back to
+	filter    *ignore.Matcher
+}
+
+// newDirWalk starts a directory transfer, leaving out the files matching the
+// patterns of ignoreFile, read with readFile, and the Exclude patterns.
+func (s *sshSession) newDirWalk(readFile func(string) ([]byte, error), ignoreFile string) (dirWalk, error) {
+	w := dirWalk{ancestors: map[string]bool{}, filter: ignore.New("/" + IgnoreFile)}
+	if patterns, err := readFile(ignoreFile); err == nil {
+		w.filter.Add(strings.Split(string(patterns), "\n")...)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return w, err
+	}
+	w.filter.Add(s.transfer.Exclude...)
+	for _, p := range s.transfer.Include {
+		w.filter.Add("!" + p)
+	}
+	return w, nil
+}
+
+// child returns the walk of the subdirectory name.
+func (w dirWalk) child(name string) dirWalk {
+	w.rel = path.Join(w.rel, name)
+	return w
+}
+
+// excluded reports whether the entry name of the directory is left out.
+func (w dirWalk) excluded(name string, isDir bool) bool {
+	return w.filter.Match(path.Join(w.rel, name), isDir)
+}
+
+const VerifySHA256 = "sha256"
+
+// resumeBlock is the size of the block at the end of a partial file that must
+// match the source for a transfer to resume.
+const resumeBlock = 64 * 1024
+
+// SetTransferOptions changes how the following transfers copy files.
+func (s *sshSession) SetTransferOptions(opt TransferOptions) {
+	s.transfer = opt
+	s.owner = nil
+}
+
+// ParseMode parses octal permission bits such as 0640, 640 or 0o640.
+func ParseMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(strings.TrimPrefix(mode, "0o"), 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %q, use octal permission bits such as 0644", mode)
+	}
+	return fileMode(uint32(m)), nil
+}
+
+// owner is the uid and gid uploaded files are given, -1 keeps the one the
+// file has.
+type owner struct {
+	uid, gid int64
+}
+
+// lookupID returns the id of a user or group name in the server file
+// /etc/passwd or /etc/group, or the number when name is one.
+func (s *sshSession) lookupID(name, file string) (int64, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return int64(id), nil
+	}
+	entries, err := s.readRemoteFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("unable to look up %s on the server: %w", name, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(entries))
+	for scanner.Scan() {
+		// name:password:id:...
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) > 2 && fields[0] == name {
+			return strconv.ParseInt(fields[2], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%q not found in %s on the server", name, file)
+}
+
+// readRemoteFile returns the content of the remote file name.
+func (s *sshSession) readRemoteFile(name string) ([]byte, error) {
+	f, err := s.sftp.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.close()
+	var content bytes.Buffer
+	if _, err := f.writeTo(&content, 0, false); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+// uploadOwner returns the uid and gid of the Owner and Group transfer
+// options, or nil when neither is set.
+func (s *sshSession) uploadOwner() (*owner, error) {
+	if s.transfer.Owner == "" && s.transfer.Group == "" {
+		return nil, nil
+	}
+	if s.owner != nil {
+		return s.owner, nil
+	}
+	o := &owner{uid: -1, gid: -1}
+	var err error
+	if s.transfer.Owner != "" {
+		if o.uid, err = s.lookupID(s.transfer.Owner, "/etc/passwd"); err != nil {
+			return nil, err
+		}
+	}
+	if s.transfer.Group != "" {
+		if o.gid, err = s.lookupID(s.transfer.Group, "/etc/group"); err != nil {
+			return nil, err
+		}
+	}
+	s.owner = o
+	return o, nil
+}
+
+// uploadAttrs returns the attributes given to the remote file or directory
+// dest uploaded from the local fi, mode being the permission bits asked for.
+func (s *sshSession) uploadAttrs(fi os.FileInfo, dest string, mode os.FileMode) (fileAttrs, error) {
+	var attrs fileAttrs
+	if s.transfer.Preserve {
+		attrs = localAttrs(fi)
+	}
+	if mode != 0 {
+		attrs.flags |= sshFileXferAttrPermissions
+		attrs.perm = unixPerm(mode)
+	}
+	o, err := s.uploadOwner()
+	if err != nil || o == nil {
+		return attrs, err
+	}
+	attrs.flags |= sshFileXferAttrUIDGID
+	attrs.uid, attrs.gid = uint32(o.uid), uint32(o.gid)
+	if o.uid < 0 || o.gid < 0 {
+		// uid and gid are always set together
+		stat, err := s.sftp.Stat(dest)
+		if err != nil {
+			return attrs, err
+		}
+		if o.uid < 0 {
+			attrs.uid = stat.uid
+		}
+		if o.gid < 0 {
+			attrs.gid = stat.gid
+		}
+	}
+	return attrs, nil
+}
+
+// maxSymlinks is the number of symbolic links followed to the file an upload
+// writes, as the Linux kernel does.
+const maxSymlinks = 40
+
+// partName returns the sibling of dest an upload is written to before it
+// replaces dest.
+func partName(dest string) string {
+	return path.Join(path.Dir(dest), "."+path.Base(dest)+".mdeploy-part")
+}
+
+// resumeOffset returns the offset a transfer of src resumes from, given the
+// partial file it left. It is the size of partial when the block ending there
+// hashes the same in both files and 0 otherwise.
+func resumeOffset(src io.ReaderAt, srcSize int64, partial io.ReaderAt, partialSize int64) (int64, error) {
+	if partialSize <= 0 || partialSize > srcSize {
+		return 0, nil
+	}
+	n := min(partialSize, resumeBlock)
+	srcBlock, partialBlock := make([]byte, n), make([]byte, n)
+	if _, err := src.ReadAt(srcBlock, partialSize-n); err != nil {
+		return 0, err
+	}
+	if _, err := partial.ReadAt(partialBlock, partialSize-n); err != nil {
+		return 0, err
+	}
+	if sha256.Sum256(srcBlock) != sha256.Sum256(partialBlock) {
+		return 0, nil
+	}
+	return partialSize, nil
+}
+
+// ParseVerify checks the hash of a verified transfer.
+func ParseVerify(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return "", nil
+	case VerifySHA256:
+		return VerifySHA256, nil
+	}
+	return "", fmt.Errorf("unsupported verify hash %q, use %s", s, VerifySHA256)
+}
+
+// localHash returns the hash of the local data of a verified transfer and nil
+// when it is not verified. It hashes the first n bytes of prefix, transferred
+// before a resume, the rest is hashed as it is streamed.
+func (s *sshSession) localHash(prefix io.ReaderAt, n int64) (hash.Hash, error) {
+	if s.transfer.Verify == "" {
+		return nil, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(prefix, 0, n)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// verify compares the hash h of the local data of a transfer with the remote
+// file. The caller names the file in the error.
+func (s *sshSession) verify(h hash.Hash, remote string) error {
+	if h == nil {
+		return nil
+	}
+	local := hex.EncodeToString(h.Sum(nil))
+	digest, err := s.remoteHash(remote)
+	if err != nil {
+		return fmt.Errorf("cannot verify: %w", err)
+	}
+	if digest != local {
+		return fmt.Errorf("%s mismatch, local %s, remote %s", s.transfer.Verify, local, digest)
+	}
+	return nil
+}
+
+// remoteHash hashes the remote file with the check-file extension, or with
+// sha256sum on servers without it or where it fails.
+func (s *sshSession) remoteHash(remote string) (string, error) {
+	if s.sftp.HasExtension(extCheckFile) {
+		if sum, err := s.sftp.CheckFile(remote, VerifySHA256); err == nil {
+			return hex.EncodeToString(sum), nil
+		}
+	}
+	var out bytes.Buffer
+	if err := s.Exec(&out, "sha256sum", "'"+strings.ReplaceAll(remote, "'", `'\''`)+"'"); err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no output from sha256sum")
+	}
+	// names with a backslash or newline are escaped, the hash prefixed by \
+	return strings.TrimPrefix(fields[0], "\\"), nil
+}
+
+// fileHash returns the hex encoded sha256 of the local file name.
+func fileHash(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// transferred reports a file of a directory copied since start.
+func transferred(progress io.Writer, name string, start time.Time) {
+	if progress == nil {
+		return
+	}
+	seconds := time.Since(start).Seconds()
+	if seconds > 120 {
+		progress.Write([]byte(fmt.Sprintf("%s - %0.2f min\n", name, seconds/60)))
+	} else {
+		progress.Write([]byte(fmt.Sprintf("%s - %0.2f sec\n", name, seconds)))
+	}
+}
+
+// linked reports a symbolic link of a directory recreated.
+func linked(progress io.Writer, name, target string) {
+	if progress != nil {
+		progress.Write([]byte(fmt.Sprintf("%s -> %s\n", name, target)))
+	}
+}
+
+// skipped reports a file of a directory that is not copied.
+func skipped(progress io.Writer, name, reason string) {
+	if progress != nil {
+		progress.Write([]byte(fmt.Sprintf("%s - skipped, %s\n", name, reason)))
+	}
+}
+
+// deleted reports a file removed by a sync.
+func deleted(progress io.Writer, name string) {
+	if progress != nil {
+		progress.Write([]byte(fmt.Sprintf("%s - deleted\n", name)))
+	}
+}
@@ -0,0 +1,264 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ForwardKind int
+
+const (
+	// LocalForward listens locally and connects from the server, like ssh -L.
+	LocalForward ForwardKind = iota
+	// RemoteForward listens on the server and connects locally, like ssh -R.
+	RemoteForward
+	// DynamicForward runs a local SOCKS5 proxy connecting from the server,
+	// like ssh -D.
+	DynamicForward
+)
+
+// Forward is a port forwarding. Listen is local for LocalForward and
+// DynamicForward and on the server for RemoteForward. Target is unused for
+// DynamicForward.
+type Forward struct {
+	Kind   ForwardKind
+	Listen string
+	Target string
+}
+
+func (f Forward) String() string {
+	switch f.Kind {
+	case LocalForward:
+		return fmt.Sprintf("%s -> %s (remote)", f.Listen, f.Target)
+	case RemoteForward:
+		return fmt.Sprintf("%s (remote) -> %s", f.Listen, f.Target)
+	}
+	return fmt.Sprintf("%s (SOCKS5)", f.Listen)
+}
+
+// splitForward splits a forwarding spec at colons outside of brackets and
+// removes the brackets.
+func splitForward(spec string) []string {
+	var fields []string
+	var field strings.Builder
+	inBrackets := false
+	for _, r := range spec {
+		switch {
+		case r == '[':
+			inBrackets = true
+		case r == ']':
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// forwardAddress joins host and port. An empty bind address is localhost and
+// "*" binds every interface, the host connected to must be named.
+func forwardAddress(host, port string, bind bool) (string, error) {
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	switch {
+	case bind && host == "":
+		host = "localhost"
+	case bind && host == "*":
+		host = ""
+	case host == "" || host == "*":
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ParseForward parses the [bind_address:]port:host:hostport spec of ssh -L
+// and -R, or the [bind_address:]port spec of ssh -D. Without a bind address
+// only localhost is listened on, "*" listens on every interface.
+func ParseForward(kind ForwardKind, spec string) (Forward, error) {
+	fields := splitForward(spec)
+	f := Forward{Kind: kind}
+	var err error
+	if kind == DynamicForward {
+		switch len(fields) {
+		case 1:
+			f.Listen, err = forwardAddress("", fields[0], true)
+		case 2:
+			f.Listen, err = forwardAddress(fields[0], fields[1], true)
+		default:
+			err = fmt.Errorf("want [bind_address:]port")
+		}
+	} else {
+		switch len(fields) {
+		case 3:
+			f.Listen, err = forwardAddress("", fields[0], true)
+		case 4:
+			f.Listen, err = forwardAddress(fields[0], fields[1], true)
+			fields = fields[1:]
+		default:
+			err = fmt.Errorf("want [bind_address:]port:host:hostport")
+		}
+		if err == nil {
+			f.Target, err = forwardAddress(fields[1], fields[2], false)
+		}
+	}
+	if err != nil {
+		return f, fmt.Errorf("invalid forwarding %q: %w", spec, err)
+	}
+	return f, nil
+}
+
+// Forward starts the port forwarding over the session's connection. It runs
+// until the returned listener is closed or the connection is lost.
+func (s *sshSession) Forward(f Forward) (net.Listener, error) {
+	client := s.conn.client
+	switch f.Kind {
+	case LocalForward:
+		l, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			return nil, err
+		}
+		go serveForward(l, func(net.Conn) (net.Conn, error) {
+			return client.Dial("tcp", f.Target)
+		})
+		return l, nil
+	case RemoteForward:
+		l, err := client.Listen("tcp", f.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("server refused to listen on %s: %w", f.Listen, err)
+		}
+		go serveForward(l, func(net.Conn) (net.Conn, error) {
+			return net.Dial("tcp", f.Target)
+		})
+		return l, nil
+	case DynamicForward:
+		l, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			return nil, err
+		}
+		go serveForward(l, func(c net.Conn) (net.Conn, error) {
+			return socks5Accept(c, func(address string) (net.Conn, error) {
+				return client.Dial("tcp", address)
+			})
+		})
+		return l, nil
+	}
+	return nil, fmt.Errorf("unknown forwarding kind %d", f.Kind)
+}
+
+func serveForward(l net.Listener, dial func(net.Conn) (net.Conn, error)) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			t, err := dial(c)
+			if err != nil {
+				c.Close()
+				return
+			}
+			relay(c, t)
+		}()
+	}
+}
+
+func relay(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+// socks5Accept answers the SOCKS5 handshake of a client without
+// authentication and connects to the requested address with dial.
+func socks5Accept(c net.Conn, dial func(address string) (net.Conn, error)) (net.Conn, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c, head); err != nil {
+		return nil, err
+	}
+	if head[0] != 0x05 {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(methods, []byte{0x00}) {
+		c.Write([]byte{0x05, 0xff})
+		return nil, fmt.Errorf("no acceptable SOCKS authentication method")
+	}
+	if _, err := c.Write([]byte{0x05, 0x00}); err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(c, req); err != nil {
+		return nil, err
+	}
+	var host string
+	switch req[3] {
+	case 0x01, 0x04:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == 0x04 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(c, l); err != nil {
+			return nil, err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		c.Write([]byte{0x05, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", req[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c, port); err != nil {
+		return nil, err
+	}
+	if req[1] != 0x01 {
+		c.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+
+	t, err := dial(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		c.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	if _, err := c.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
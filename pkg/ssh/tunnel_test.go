@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		kind   ForwardKind
+		spec   string
+		listen string
+		target string // empty when the spec is invalid
+	}{
+		{LocalForward, "8080:localhost:80", "localhost:8080", "localhost:80"},
+		{LocalForward, "127.0.0.1:8080:db:5432", "127.0.0.1:8080", "db:5432"},
+		{LocalForward, "*:8080:db:5432", ":8080", "db:5432"},
+		{LocalForward, ":8080:db:5432", "localhost:8080", "db:5432"},
+		{LocalForward, "[::1]:8080:[fe80::1]:80", "[::1]:8080", "[fe80::1]:80"},
+		{LocalForward, "8080:[::1]:80", "localhost:8080", "[::1]:80"},
+		{LocalForward, "8080:*:80", "", ""},
+		{LocalForward, "8080::80", "", ""},
+		{LocalForward, "8080:db", "", ""},
+		{LocalForward, "8080:db:http", "", ""},
+		{LocalForward, "70000:db:80", "", ""},
+		{RemoteForward, "9000:localhost:3000", "localhost:9000", "localhost:3000"},
+		{RemoteForward, "*:9000:localhost:3000", ":9000", "localhost:3000"},
+		{RemoteForward, "[::]:9000:[::1]:3000", "[::]:9000", "[::1]:3000"},
+		{RemoteForward, "9000:*:3000", "", ""},
+		{DynamicForward, "1080", "localhost:1080", ""},
+		{DynamicForward, "*:1080", ":1080", ""},
+		{DynamicForward, "[::1]:1080", "[::1]:1080", ""},
+		{DynamicForward, "1080:db:80", "", ""},
+	}
+	for _, tt := range tests {
+		f, err := ParseForward(tt.kind, tt.spec)
+		if tt.listen == "" {
+			if err == nil {
+				t.Errorf("ParseForward(%d, %q) accepted: %v", tt.kind, tt.spec, f)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseForward(%d, %q): %v", tt.kind, tt.spec, err)
+			continue
+		}
+		if f.Kind != tt.kind || f.Listen != tt.listen || f.Target != tt.target {
+			t.Errorf("ParseForward(%d, %q) = %+v, want listen %q target %q", tt.kind, tt.spec, f, tt.listen, tt.target)
+		}
+	}
+}
+
+func TestSOCKS5AcceptNoAcceptableMethod(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	dialed := false
+	done := make(chan error, 1)
+	go func() {
+		_, err := socks5Accept(server, func(string) (net.Conn, error) {
+			dialed = true
+			return nil, nil
+		})
+		server.Close()
+		done <- err
+	}()
+
+	// username and password only
+	client.Write([]byte{0x05, 0x01, 0x02})
+	reply, _ := io.ReadAll(client)
+	if !bytes.Equal(reply, []byte{0x05, 0xff}) {
+		t.Errorf("got reply %x, want 05ff", reply)
+	}
+	if err := <-done; err == nil {
+		t.Error("handshake accepted")
+	}
+	if dialed {
+		t.Error("dialed without a request")
+	}
+}
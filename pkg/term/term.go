@@ -17,8 +17,18 @@ func GetWinSize() (int, int, error) {
 	return getWinSize()
 }
 
+// IsTerminal reports whether stdin is a terminal, otherwise secrets cannot be
+// read with the echo disabled.
+func IsTerminal() bool {
+	return isTerminal()
+}
+
 func ReadPassword() (s string, err error) {
-	os.Stdout.WriteString("Password: ")
+	return ReadSecret("Password: ")
+}
+
+func ReadSecret(prompt string) (s string, err error) {
+	os.Stdout.WriteString(prompt)
 	b, err := readPassword()
 	if b != nil {
 		s = string(b)
@@ -26,6 +36,17 @@ func ReadPassword() (s string, err error) {
 	return
 }
 
+// ReadLine writes prompt and reads a line, hiding the input unless echo is
+// set.
+func ReadLine(prompt string, echo bool) (string, error) {
+	if !echo {
+		return ReadSecret(prompt)
+	}
+	os.Stdout.WriteString(prompt)
+	b, err := readPasswordLine(os.Stdin)
+	return string(b), err
+}
+
 func readPasswordLine(io io.Reader) ([]byte, error) {
 	var buf [1]byte
 	var ret []byte
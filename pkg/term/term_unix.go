@@ -19,6 +19,11 @@ func getWinSize() (width int, height int, err error) {
 	return
 }
 
+func isTerminal() bool {
+	_, err := unix.IoctlGetTermios(syscall.Stdin, unix.TCGETS)
+	return err == nil
+}
+
 type passwordReader int
 
 func (pwd passwordReader) Read(p []byte) (n int, err error) {
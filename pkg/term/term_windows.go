@@ -21,6 +21,11 @@ func getWinSize() (width int, height int, err error) {
 	return
 }
 
+func isTerminal() bool {
+	var st uint32
+	return windows.GetConsoleMode(windows.Handle(syscall.Stdin), &st) == nil
+}
+
 func readPassword() ([]byte, error) {
 	fd := syscall.Stdin
 	var st uint32
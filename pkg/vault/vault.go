@@ -0,0 +1,173 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/san-gg/mdeploy/pkg/credential"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// PassphraseEnv is the environment variable holding the vault passphrase.
+const PassphraseEnv = "MDEPLOY_VAULT_PASSWORD"
+
+// header starts every encrypted vault file. The base64 encoded body holds the
+// scrypt salt, the secretbox nonce and the sealed secrets.
+const header = "$MDEPLOY_VAULT;1;scrypt;secretbox"
+
+const (
+	saltSize  = 16
+	nonceSize = 24
+	lineWidth = 76
+)
+
+var ErrPassphrase = errors.New("wrong vault passphrase or corrupted vault")
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Secrets maps secret names to their values.
+type Secrets map[string]string
+
+func deriveKey(passphrase string, salt []byte) (*[32]byte, error) {
+	k, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// IsEncrypted reports whether data is an encrypted vault.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(header+"\n"))
+}
+
+// Encrypt seals plaintext with a key derived from passphrase.
+func Encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty vault passphrase")
+	}
+	body := make([]byte, saltSize+nonceSize, saltSize+nonceSize+len(plaintext)+secretbox.Overhead)
+	if _, err := rand.Read(body); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, body[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], body[saltSize:])
+	body = secretbox.Seal(body, plaintext, &nonce, key)
+
+	encoded := base64.StdEncoding.EncodeToString(body)
+	var out bytes.Buffer
+	out.WriteString(header + "\n")
+	for len(encoded) > lineWidth {
+		out.WriteString(encoded[:lineWidth] + "\n")
+		encoded = encoded[lineWidth:]
+	}
+	out.WriteString(encoded + "\n")
+	return out.Bytes(), nil
+}
+
+// Decrypt opens a vault sealed by Encrypt.
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("not an encrypted vault")
+	}
+	encoded := strings.Join(strings.Fields(string(data[len(header)+1:])), "")
+	body, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(body) < saltSize+nonceSize+secretbox.Overhead {
+		return nil, ErrPassphrase
+	}
+	key, err := deriveKey(passphrase, body[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], body[saltSize:])
+	plaintext, ok := secretbox.Open(nil, body[saltSize+nonceSize:], &nonce, key)
+	if !ok {
+		return nil, ErrPassphrase
+	}
+	return plaintext, nil
+}
+
+// Parse reads the YAML mapping of secret names to values held by a vault.
+func Parse(plaintext []byte) (Secrets, error) {
+	secrets := Secrets{}
+	if err := yaml.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("vault must map secret names to values: %w", err)
+	}
+	for name := range secrets {
+		if !nameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid secret name %q: use letters, digits, '_', '.' and '-'", name)
+		}
+	}
+	return secrets, nil
+}
+
+// Passphrase asks for the vault passphrase in order: MDEPLOY_VAULT_PASSWORD,
+// the passphrase file and the terminal.
+func Passphrase(file string) credential.Provider {
+	var fromFile credential.Provider
+	if file != "" {
+		fromFile = credential.File(file)
+	}
+	return credential.Once(credential.Chain(
+		credential.Env(PassphraseEnv),
+		fromFile,
+		credential.Prompt("Vault passphrase: "),
+	))
+}
+
+// Open decrypts the vault file and parses its secrets.
+func Open(file string, passphrase credential.Provider) (Secrets, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := passphrase.Secret()
+	if err != nil {
+		return nil, err
+	}
+	if pass == "" {
+		return nil, fmt.Errorf("no passphrase for vault %s", file)
+	}
+	plaintext, err := Decrypt(data, pass)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return Parse(plaintext)
+}
+
+// WriteFile replaces file with data, written to a temporary file first so
+// that an interrupted write leaves the old vault intact.
+func WriteFile(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".vault-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
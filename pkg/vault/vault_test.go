@@ -0,0 +1,119 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/san-gg/mdeploy/pkg/credential"
+)
+
+const plaintext = "db_password: s3cret\napi.token: abc-123\n"
+
+func TestEncryptDecrypt(t *testing.T) {
+	data, err := Encrypt([]byte(plaintext), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) {
+		t.Fatal("encrypted vault not recognized")
+	}
+	if bytes.Contains(data, []byte("s3cret")) {
+		t.Error("secret readable in the vault")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if len(line) > lineWidth {
+			t.Errorf("line of %d characters", len(line))
+		}
+	}
+	got, err := Decrypt(data, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != plaintext {
+		t.Errorf("got %q, want %q", got, plaintext)
+	}
+
+	again, err := Encrypt([]byte(plaintext), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, data) {
+		t.Error("same salt and nonce used twice")
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	data, err := Encrypt([]byte(plaintext), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(data, "Passphrase"); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("wrong passphrase: got %v, want ErrPassphrase", err)
+	}
+
+	// a character of the sealed secrets changed, past the salt and nonce
+	corrupted := bytes.Clone(data)
+	i := len(header) + 1 + 60
+	if corrupted[i] == 'A' {
+		corrupted[i] = 'B'
+	} else {
+		corrupted[i] = 'A'
+	}
+	if _, err := Decrypt(corrupted, "passphrase"); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("corrupted vault: got %v, want ErrPassphrase", err)
+	}
+	if _, err := Decrypt([]byte(plaintext), "passphrase"); err == nil {
+		t.Error("plain file decrypted")
+	}
+	if _, err := Encrypt([]byte(plaintext), ""); err == nil {
+		t.Error("encrypted with an empty passphrase")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vault.yml")
+	data, err := Encrypt([]byte(plaintext), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(file, data); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(file); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("vault written with mode %v, %v", fi.Mode(), err)
+	}
+
+	secrets, err := Open(file, credential.ProviderFunc(func() (string, error) { return "passphrase", nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["db_password"] != "s3cret" || secrets["api.token"] != "abc-123" {
+		t.Errorf("got %v", secrets)
+	}
+	_, err = Open(file, credential.ProviderFunc(func() (string, error) { return "wrong", nil }))
+	if !errors.Is(err, ErrPassphrase) {
+		t.Errorf("wrong passphrase: got %v, want ErrPassphrase", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		plaintext string
+		ok        bool
+	}{
+		{plaintext, true},
+		{"# no secrets yet\n", true},
+		{"db password: s3cret\n", false},
+		{"- s3cret\n", false},
+		{"db_password: [s3cret\n", false},
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.plaintext)); (err == nil) != tt.ok {
+			t.Errorf("Parse(%q): got error %v", tt.plaintext, err)
+		}
+	}
+}